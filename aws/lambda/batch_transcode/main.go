@@ -3,65 +3,209 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/mediaconvert"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+	"github.com/molpadia/molpastream/internal/infrastructure/transcoder"
 )
 
-const jobSettingPath = "job.json"
+const (
+	jobSettingPath  = "job.json"
+	maxSaveAttempts = 3
+)
+
+var (
+	// The error is returned if the object was created before the upload of the video was marked as completed,
+	// so the record is processed again later.
+	errUploadInProgress = errors.New("upload of video is in progress")
+	// The error is returned if the object has been claimed by another delivery of the event whose job has not been
+	// recorded yet, so the record is processed again and the claim is taken over once it expires.
+	errClaimPending = errors.New("transcoding of video is claimed without a job")
+)
 
-// Get URI path for a file stored in S3 bucket.
-func s3Path(bucket, key string) string {
-	return fmt.Sprintf("s3://%s/%s", bucket, key)
+// The processor launches the transcoding jobs of the videos uploaded to S3.
+type processor struct {
+	videos      repository.VideoRepository
+	transcoders func(bucket string) repository.Transcoder // Get the transcoder of the videos uploaded to the bucket.
 }
 
 // Load the configuration of mediaconvert job settings.
-func loadJobSettings() (*mediaconvert.JobSettings, error) {
+func loadJobSettings() ([]byte, error) {
 	buf, err := os.ReadFile(jobSettingPath)
 	if err != nil {
 		log.Printf("failed to load job setting file: %v", err)
 		return nil, err
 	}
-	var js *mediaconvert.JobSettings
-	if err = json.Unmarshal(buf, &js); err != nil {
-		log.Printf("failed to unmarshal job settings, %v", err)
-		return nil, err
+	return buf, nil
+}
+
+// Process the S3 events carried by the SQS messages, and report the messages whose records failed,
+// so that only the failed messages are delivered again.
+func (p *processor) processMessages(event events.SQSEvent) events.SQSEventResponse {
+	resp := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	for _, msg := range event.Records {
+		// The test event sent by S3 once the notification is configured has no records.
+		var s3Event events.S3Event
+		if err := json.Unmarshal([]byte(msg.Body), &s3Event); err != nil {
+			log.Printf("message %s is not an S3 event and is dropped: %v", msg.MessageId, err)
+			continue
+		}
+		if err := p.processEvent(s3Event); err != nil {
+			log.Printf("failed to process message %s: %v", msg.MessageId, err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+		}
 	}
-	return js, nil
+	return resp
+}
+
+// Process every record of the S3 event even if some of the records fail, the error of the failed records is returned.
+// The records claimed before are skipped once the event is delivered again.
+func (p *processor) processEvent(event events.S3Event) error {
+	var failed []string
+	for _, record := range event.Records {
+		if err := p.processRecord(record); err != nil {
+			log.Printf("failed to process object %s of bucket %s: %v", record.S3.Object.Key, record.S3.Bucket.Name, err)
+			failed = append(failed, record.S3.Object.Key)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d records failed: %s", len(failed), len(event.Records), strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-// Invoke the AWS Lambda function to trancode the given video to outputs.
-func handler(ctx context.Context, event events.S3Event) error {
-	bucket := event.Records[0].S3.Bucket.Name
-	key := event.Records[0].S3.Object.Key
-	js, err := loadJobSettings()
+// Launch the transcoding job of the video uploaded as the object of the record, and mark the video as transcoding by the job.
+// The video is marked as failed if the job cannot be launched.
+func (p *processor) processRecord(record events.S3EventRecord) error {
+	if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+		return nil
+	}
+	// The keys of objects are URL-encoded in S3 events, e.g. spaces are encoded as '+'.
+	key, err := url.QueryUnescape(record.S3.Object.Key)
+	if err != nil {
+		log.Printf("object key %q is malformed: %v", record.S3.Object.Key, err)
+		return nil
+	}
+	if record.S3.Object.Size == 0 || strings.HasSuffix(key, "/") {
+		log.Printf("object %s is empty and is not transcoded", key)
+		return nil
+	}
+	video, err := p.videos.GetById(key)
 	if err != nil {
 		return err
 	}
-	js.Inputs[0].FileInput = aws.String(s3Path(bucket, key))
-	js.OutputGroups[0].OutputGroupSettings.HlsGroupSettings.Destination = aws.String(s3Path(os.Getenv("AWS_VOD_HLS_BUCKET"), key))
-	// Create a mediaconvert job
-	mc := mediaconvert.New(session.Must(session.NewSession(&aws.Config{
-		Endpoint: aws.String(os.Getenv("AWS_VOD_MEDIACONVERT_URL")),
-	})))
-	out, err := mc.CreateJob(&mediaconvert.CreateJobInput{
-		Role:     aws.String(os.Getenv("AWS_VOD_MEDIACONVERT_ROLE_ARN")),
-		Settings: js,
-	})
+	if video == nil {
+		log.Printf("object %s is not a video", key)
+		return nil
+	}
+	if video.Status == entity.StatusCreated || video.Status == entity.StatusUploading {
+		return fmt.Errorf("video %s: %w", key, errUploadInProgress)
+	}
+	// The job of the video has been submitted, e.g. the video is transcoding or ready.
+	if video.Status != entity.StatusUploaded {
+		log.Printf("video %s cannot be transcoded in %s status", key, video.Status)
+		return nil
+	}
+	// Events are delivered at least once, so the job is only submitted by the first event of the source object.
+	// The source object is identified by its version in versioned buckets, or its entity tag.
+	source := record.S3.Object.VersionID
+	if source == "" {
+		source = strings.Trim(record.S3.Object.ETag, `"`)
+	}
+	video, err = p.videos.ClaimTranscode(key, source, time.Now())
+	if errors.Is(err, repository.ErrTranscodeClaimed) {
+		return p.checkClaim(key, source)
+	}
 	if err != nil {
-		log.Printf("failed to launch mediaconvert job: %v", err)
 		return err
 	}
-	log.Printf("mediaconvert job launched %v", out)
+	job, err := p.transcoders(record.S3.Bucket.Name).Transcode(video)
+	if err != nil {
+		log.Printf("failed to launch transcoding job of video %s: %v", key, err)
+		if terr := video.Transition(entity.StatusFailed, time.Now()); terr != nil {
+			return err
+		}
+		return p.videos.Save(video)
+	}
+	return p.startTranscode(video, source, job)
+}
+
+// Check the claim of the source object held by another delivery of the event. The record is skipped if the job of the
+// claim has been recorded, or it fails until the job is recorded or the claim expires.
+func (p *processor) checkClaim(key, source string) error {
+	video, err := p.videos.GetById(key)
+	if err != nil {
+		return err
+	}
+	if video != nil && video.Status == entity.StatusUploaded && video.TranscodeClaim != nil && video.TranscodeClaim.JobId == "" {
+		return fmt.Errorf("video %s: %w", key, errClaimPending)
+	}
+	log.Printf("transcoding of object %s of source %s has been claimed", key, source)
 	return nil
 }
 
+// Mark the video as transcoding by the job and record the job on the claim of the source object,
+// the latest video is marked again if it was modified concurrently.
+func (p *processor) startTranscode(video *entity.Video, source string, job *entity.TranscodeJob) error {
+	for attempt := 1; ; attempt++ {
+		if err := video.StartTranscode(job, time.Now()); err != nil {
+			return err
+		}
+		if c := video.TranscodeClaim; c != nil && c.Source == source {
+			c.JobId = job.Id
+		}
+		err := p.videos.Save(video)
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) || attempt == maxSaveAttempts {
+			return err
+		}
+		if video, err = p.videos.GetById(video.Id); err != nil {
+			return err
+		}
+		if video == nil {
+			return repository.ErrVideoNotFound
+		}
+	}
+}
+
+// Invoke the AWS Lambda function to trancode the videos uploaded to S3, the S3 events are delivered by SQS.
+// The function reports partial batch failures, the event source mapping is expected to enable ReportBatchItemFailures.
+func handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	settings, err := loadJobSettings()
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+	sess := session.Must(session.NewSession())
+	mc := mediaconvert.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint: aws.String(os.Getenv("AWS_VOD_MEDIACONVERT_URL")),
+	})))
+	p := &processor{
+		videos: persistence.NewDynamoVideoRepository(sess, os.Getenv("AWS_VOD_DB_NAME")),
+		transcoders: func(bucket string) repository.Transcoder {
+			return transcoder.NewMediaConvertTranscoder(mc, persistence.NewS3Storage(sess, bucket), transcoder.MediaConvertConfig{
+				Role:         os.Getenv("AWS_VOD_MEDIACONVERT_ROLE_ARN"),
+				Settings:     settings,
+				SourceBucket: bucket,
+				StreamBucket: os.Getenv("AWS_VOD_HLS_BUCKET"),
+			})
+		},
+	}
+	return p.processMessages(event), nil
+}
+
 func main() {
 	lambda.Start(handler)
 }
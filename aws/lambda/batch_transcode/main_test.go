@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+)
+
+// Get the record of the S3 event of the object created in the input bucket.
+func record(eventName, key string, size int64, etag string) string {
+	return fmt.Sprintf(`{"eventSource": "aws:s3", "eventName": %q, "s3": {"bucket": {"name": "input"}, "object": {"key": %q, "size": %d, "eTag": %q}}}`, eventName, key, size, etag)
+}
+
+// Get the S3 event of the given records.
+func s3Event(records ...string) string {
+	return `{"Records": [` + strings.Join(records, ",") + `]}`
+}
+
+// Create the repository of the videos in the given statuses.
+func newVideoRepository(t *testing.T, statuses map[string]string) repository.VideoRepository {
+	videos := persistence.NewMemoryVideoRepository()
+	for id, status := range statuses {
+		video := entity.NewVideo(id, "", "", "video/mp4", 100, nil, nil)
+		video.Status = status
+		if err := videos.Save(video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return videos
+}
+
+func TestProcessEvent(t *testing.T) {
+	tests := []struct {
+		event            string
+		statuses         map[string]string
+		transcodeErr     error
+		expectedStatuses map[string]string
+		expectedJobs     []string
+		expectedErr      error
+	}{
+		// Keys are URL-decoded, spaces are encoded as '+' and special characters are escaped.
+		{
+			s3Event(record("ObjectCreated:CompleteMultipartUpload", "my+video%3D1", 100, "abc")),
+			map[string]string{"my video=1": entity.StatusUploaded},
+			nil,
+			map[string]string{"my video=1": entity.StatusTranscoding},
+			[]string{"my video=1"},
+			nil,
+		},
+		// Every record of the batch is processed.
+		{
+			s3Event(record("ObjectCreated:Put", "1", 100, "abc"), record("ObjectCreated:Put", "2", 100, "def")),
+			map[string]string{"1": entity.StatusUploaded, "2": entity.StatusUploaded},
+			nil,
+			map[string]string{"1": entity.StatusTranscoding, "2": entity.StatusTranscoding},
+			[]string{"1", "2"},
+			nil,
+		},
+		{s3Event(), nil, nil, nil, nil, nil},
+		// Zero-byte objects, objects of unknown videos and removed objects are skipped.
+		{
+			s3Event(record("ObjectCreated:Put", "1", 0, "abc"), record("ObjectCreated:Put", "unknown", 100, "abc"), record("ObjectRemoved:Delete", "2", 100, "abc")),
+			map[string]string{"1": entity.StatusUploaded, "2": entity.StatusUploaded},
+			nil,
+			map[string]string{"1": entity.StatusUploaded, "2": entity.StatusUploaded},
+			nil,
+			nil,
+		},
+		// The videos whose job has been submitted are skipped.
+		{
+			s3Event(record("ObjectCreated:Put", "1", 100, "abc")),
+			map[string]string{"1": entity.StatusTranscoding},
+			nil,
+			map[string]string{"1": entity.StatusTranscoding},
+			nil,
+			nil,
+		},
+		{
+			s3Event(record("ObjectCreated:Put", "1", 100, "abc")),
+			map[string]string{"1": entity.StatusReady},
+			nil,
+			map[string]string{"1": entity.StatusReady},
+			nil,
+			nil,
+		},
+		// The record of the video still uploading fails, while the other records are processed.
+		{
+			s3Event(record("ObjectCreated:Put", "1", 100, "abc"), record("ObjectCreated:Put", "2", 100, "def")),
+			map[string]string{"1": entity.StatusUploading, "2": entity.StatusUploaded},
+			nil,
+			map[string]string{"1": entity.StatusUploading, "2": entity.StatusTranscoding},
+			[]string{"2"},
+			errors.New("1 of 2 records failed: 1"),
+		},
+		// The video is marked as failed if the job cannot be launched.
+		{
+			s3Event(record("ObjectCreated:Put", "1", 100, "abc")),
+			map[string]string{"1": entity.StatusUploaded},
+			errors.New("invalid job settings"),
+			map[string]string{"1": entity.StatusFailed},
+			nil,
+			nil,
+		},
+	}
+	for i, tt := range tests {
+		var event events.S3Event
+		if err := json.Unmarshal([]byte(tt.event), &event); err != nil {
+			t.Fatal(err)
+		}
+		videos := newVideoRepository(t, tt.statuses)
+		tr := &mockTranscoder{err: tt.transcodeErr}
+		p := &processor{videos, func(bucket string) repository.Transcoder { return tr }}
+		err := p.processEvent(event)
+		if fmt.Sprint(err) != fmt.Sprint(tt.expectedErr) {
+			t.Errorf("case %d: expected error (%v), got error (%v)", i, tt.expectedErr, err)
+		}
+		if fmt.Sprint(tr.videos) != fmt.Sprint(tt.expectedJobs) {
+			t.Errorf("case %d: expected jobs of videos %v, got %v", i, tt.expectedJobs, tr.videos)
+		}
+		for id, status := range tt.expectedStatuses {
+			video, err := videos.GetById(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.Status != status {
+				t.Errorf("case %d: expected status (%s) of video %s, got status (%s)", i, status, id, video.Status)
+			}
+			// The video is marked as transcoding by the job of the source object.
+			if status == entity.StatusTranscoding && tt.statuses[id] != entity.StatusTranscoding && (video.Transcode.Id != "job-"+id || video.TranscodeClaim == nil) {
+				t.Errorf("case %d: expected video %s transcoding by job-%s with claim, got job %+v", i, id, id, video.Transcode)
+			}
+		}
+	}
+}
+
+func TestProcessEventClaimed(t *testing.T) {
+	now := time.Now()
+	versioned := `{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "input"}, "object": {"key": "1", "size": 100, "eTag": "abc", "versionId": "v1"}}}`
+	tests := []struct {
+		record       string
+		claim        *entity.TranscodeClaim // The claim of the uploaded video held by another delivery of the event.
+		expectedJobs []string
+		expectedErr  error
+	}{
+		// The job of the claim has been submitted.
+		{record("ObjectCreated:Put", "1", 100, "abc"), &entity.TranscodeClaim{Source: "abc", JobId: "job0", ClaimedAt: now}, nil, nil},
+		// The job of the claim is being submitted, the record is processed again later.
+		{record("ObjectCreated:Put", "1", 100, "abc"), &entity.TranscodeClaim{Source: "abc", ClaimedAt: now}, nil, errors.New("1 of 1 records failed: 1")},
+		// The claimer crashed before the job was recorded, the expired claim is taken over.
+		{record("ObjectCreated:Put", "1", 100, "abc"), &entity.TranscodeClaim{Source: "abc", ClaimedAt: now.Add(-entity.TranscodeClaimTimeout)}, []string{"1"}, nil},
+		// Another version of the object is transcoded.
+		{versioned, &entity.TranscodeClaim{Source: "abc", JobId: "job0", ClaimedAt: now}, []string{"1"}, nil},
+	}
+	for i, tt := range tests {
+		var event events.S3Event
+		if err := json.Unmarshal([]byte(s3Event(tt.record)), &event); err != nil {
+			t.Fatal(err)
+		}
+		videos := persistence.NewMemoryVideoRepository()
+		video := entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		video.Status, video.TranscodeClaim = entity.StatusUploaded, tt.claim
+		if err := videos.Save(video); err != nil {
+			t.Fatal(err)
+		}
+		tr := &mockTranscoder{}
+		p := &processor{videos, func(bucket string) repository.Transcoder { return tr }}
+		err := p.processEvent(event)
+		if fmt.Sprint(err) != fmt.Sprint(tt.expectedErr) {
+			t.Errorf("case %d: expected error (%v), got error (%v)", i, tt.expectedErr, err)
+		}
+		if fmt.Sprint(tr.videos) != fmt.Sprint(tt.expectedJobs) {
+			t.Errorf("case %d: expected jobs of videos %v, got %v", i, tt.expectedJobs, tr.videos)
+		}
+		// The job is recorded on the claim, so that later deliveries of the event are skipped.
+		if video, err = videos.GetById("1"); err != nil {
+			t.Fatal(err)
+		}
+		if len(tt.expectedJobs) > 0 && (video.TranscodeClaim == nil || video.TranscodeClaim.JobId != "job-1") {
+			t.Errorf("case %d: expected claim recording job-1, got claim %+v", i, video.TranscodeClaim)
+		}
+	}
+}
+
+func TestProcessMessages(t *testing.T) {
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "1", Body: s3Event(record("ObjectCreated:Put", "1", 100, "abc"))},
+		{MessageId: "2", Body: s3Event(record("ObjectCreated:Put", "2", 100, "abc"))},
+		{MessageId: "3", Body: "foo"},
+		{MessageId: "4", Body: `{"Service": "Amazon S3", "Event": "s3:TestEvent", "Bucket": "input"}`},
+	}}
+	videos := newVideoRepository(t, map[string]string{"1": entity.StatusUploaded, "2": entity.StatusUploading})
+	p := &processor{videos, func(bucket string) repository.Transcoder { return &mockTranscoder{} }}
+	resp := p.processMessages(event)
+	// Only the message of the video still uploading is delivered again, malformed messages are dropped.
+	if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != "2" {
+		t.Errorf("expected batch item failure of message 2, got %+v", resp.BatchItemFailures)
+	}
+}
+
+type mockTranscoder struct {
+	videos []string
+	err    error
+}
+
+func (t *mockTranscoder) Transcode(video *entity.Video) (*entity.TranscodeJob, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	t.videos = append(t.videos, video.Id)
+	return &entity.TranscodeJob{Id: "job-" + video.Id}, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+)
+
+const maxSaveAttempts = 3
+
+// The detail of the MediaConvert job state change event.
+type jobStateChange struct {
+	Status             string            `json:"status"`
+	JobId              string            `json:"jobId"`
+	UserMetadata       map[string]string `json:"userMetadata"`
+	ErrorCode          int64             `json:"errorCode"`
+	ErrorMessage       string            `json:"errorMessage"`
+	OutputGroupDetails []struct {
+		OutputDetails []struct {
+			OutputFilePaths []string `json:"outputFilePaths"`
+		} `json:"outputDetails"`
+		PlaylistFilePaths []string `json:"playlistFilePaths"`
+	} `json:"outputGroupDetails"`
+}
+
+// Get the paths of the playlists and the files produced by the job.
+func (e *jobStateChange) outputs() []string {
+	var paths []string
+	for _, group := range e.OutputGroupDetails {
+		paths = append(paths, group.PlaylistFilePaths...)
+		for _, output := range group.OutputDetails {
+			paths = append(paths, output.OutputFilePaths...)
+		}
+	}
+	return paths
+}
+
+// Apply the result of the job to the video.
+func (e *jobStateChange) apply(video *entity.Video, now time.Time) error {
+	if e.Status == "COMPLETE" {
+		return video.CompleteTranscode(e.JobId, e.outputs(), now)
+	}
+	return video.FailTranscode(e.JobId, e.ErrorCode, e.ErrorMessage, now)
+}
+
+// The recorder records the results of the mediaconvert jobs on the transcoded videos.
+type recorder struct {
+	videos repository.VideoRepository
+}
+
+// Record the result of the job in the job state change event on the video.
+// The video is marked as ready with the outputs if the job completed, or failed with the error of the job.
+func (r *recorder) recordEvent(event events.CloudWatchEvent) error {
+	var detail jobStateChange
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return err
+	}
+	if detail.Status != "COMPLETE" && detail.Status != "ERROR" {
+		return nil
+	}
+	id := detail.UserMetadata["videoId"]
+	if id == "" {
+		log.Printf("mediaconvert job %s does not carry video ID", detail.JobId)
+		return nil
+	}
+	// The video is read again if it was modified concurrently.
+	for attempt := 1; ; attempt++ {
+		video, err := r.videos.GetById(id)
+		if err != nil {
+			return err
+		}
+		if video == nil {
+			log.Printf("video %s does not exist", id)
+			return nil
+		}
+		err = detail.apply(video, time.Now())
+		// The job may finish before it is recorded on the claim of the video, the event is retried until then.
+		pending := video.TranscodeClaim != nil && video.TranscodeClaim.JobId == ""
+		if errors.Is(err, entity.ErrUnknownTranscodeJob) && pending {
+			return fmt.Errorf("result of mediaconvert job %s is not applied to video %s: %w", detail.JobId, id, err)
+		}
+		// Events are delivered at least once, the events of outdated jobs or applied results are ignored.
+		if err != nil {
+			log.Printf("result of mediaconvert job %s is not applied to video %s: %v", detail.JobId, id, err)
+			return nil
+		}
+		err = r.videos.Save(video)
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) || attempt == maxSaveAttempts {
+			return err
+		}
+	}
+}
+
+// Invoke the AWS Lambda function to record the result of the mediaconvert job on the transcoded video.
+// The function is invoked asynchronously by EventBridge, the event is retried if an error is returned.
+func handler(ctx context.Context, event events.CloudWatchEvent) error {
+	r := &recorder{videos: persistence.NewDynamoVideoRepository(session.Must(session.NewSession()), os.Getenv("AWS_VOD_DB_NAME"))}
+	return r.recordEvent(event)
+}
+
+func main() {
+	lambda.Start(handler)
+}
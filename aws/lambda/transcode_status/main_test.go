@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+)
+
+// Get the job state change event of the job transcoding the given video.
+func jobEvent(status, jobId, videoId string) events.CloudWatchEvent {
+	detail := fmt.Sprintf(`{"status": %q, "jobId": %q, "userMetadata": {"videoId": %q}, "errorCode": 1010, "errorMessage": "invalid input",
+		"outputGroupDetails": [{"playlistFilePaths": ["s3://output/1/index.m3u8"], "outputDetails": [{"outputFilePaths": ["s3://output/1/index_720p.m3u8"]}]}]}`, status, jobId, videoId)
+	return events.CloudWatchEvent{DetailType: "MediaConvert Job State Change", Detail: []byte(detail)}
+}
+
+func TestRecordEvent(t *testing.T) {
+	now := time.Now()
+	transcoding := &entity.TranscodeJob{Id: "job1", HLS: &entity.Stream{Bucket: "output", Playlist: "1/index.m3u8"}}
+	tests := []struct {
+		event          events.CloudWatchEvent
+		status         string
+		job            *entity.TranscodeJob
+		claim          *entity.TranscodeClaim
+		expectedStatus string
+		expectedErr    error
+	}{
+		// The video is marked as ready with the outputs of the completed job.
+		{jobEvent("COMPLETE", "job1", "1"), entity.StatusTranscoding, transcoding, &entity.TranscodeClaim{Source: "abc", JobId: "job1", ClaimedAt: now}, entity.StatusReady, nil},
+		// The video is marked as failed with the error of the failed job.
+		{jobEvent("ERROR", "job1", "1"), entity.StatusTranscoding, transcoding, &entity.TranscodeClaim{Source: "abc", JobId: "job1", ClaimedAt: now}, entity.StatusFailed, nil},
+		// The job has not been recorded on the claim of the video yet, the event is retried.
+		{jobEvent("COMPLETE", "job1", "1"), entity.StatusUploaded, nil, &entity.TranscodeClaim{Source: "abc", ClaimedAt: now}, entity.StatusUploaded, entity.ErrUnknownTranscodeJob},
+		// The results of outdated jobs and the results applied already are ignored.
+		{jobEvent("COMPLETE", "job0", "1"), entity.StatusTranscoding, transcoding, &entity.TranscodeClaim{Source: "abc", JobId: "job1", ClaimedAt: now}, entity.StatusTranscoding, nil},
+		{jobEvent("ERROR", "job1", "1"), entity.StatusReady, transcoding, &entity.TranscodeClaim{Source: "abc", JobId: "job1", ClaimedAt: now}, entity.StatusReady, nil},
+		// Progressing jobs and the events of unknown videos are ignored.
+		{jobEvent("PROGRESSING", "job1", "1"), entity.StatusTranscoding, transcoding, nil, entity.StatusTranscoding, nil},
+		{jobEvent("COMPLETE", "job1", "2"), entity.StatusTranscoding, transcoding, nil, entity.StatusTranscoding, nil},
+	}
+	for i, tt := range tests {
+		videos := persistence.NewMemoryVideoRepository()
+		video := entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		video.Status, video.TranscodeClaim = tt.status, tt.claim
+		if tt.job != nil {
+			job := *tt.job
+			video.Transcode = &job
+		}
+		if err := videos.Save(video); err != nil {
+			t.Fatal(err)
+		}
+		r := &recorder{videos}
+		if err := r.recordEvent(tt.event); !errors.Is(err, tt.expectedErr) {
+			t.Errorf("case %d: expected error (%v), got error (%v)", i, tt.expectedErr, err)
+		}
+		video, err := videos.GetById("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if video.Status != tt.expectedStatus {
+			t.Errorf("case %d: expected status (%s), got status (%s)", i, tt.expectedStatus, video.Status)
+		}
+		if tt.status == tt.expectedStatus {
+			continue
+		}
+		// The streams of the completed job become the streams of the video.
+		if tt.expectedStatus == entity.StatusReady && (video.HLS == nil || len(video.Transcode.Outputs) != 2) {
+			t.Errorf("case %d: expected HLS stream with 2 outputs, got stream %+v with outputs %v", i, video.HLS, video.Transcode.Outputs)
+		}
+		if tt.expectedStatus == entity.StatusFailed && (video.Transcode.ErrorCode != 1010 || video.Transcode.ErrorMessage != "invalid input") {
+			t.Errorf("case %d: expected error 1010 of the job, got error %d (%s)", i, video.Transcode.ErrorCode, video.Transcode.ErrorMessage)
+		}
+	}
+}
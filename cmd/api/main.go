@@ -1,20 +1,51 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/mediaconvert"
 	"github.com/gorilla/mux"
 	"github.com/molpadia/molpastream/internal/app"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+	"github.com/molpadia/molpastream/internal/infrastructure/transcoder"
+	"github.com/molpadia/molpastream/internal/urlsign"
 )
 
 var (
-	addr = flag.String("addr", env("ADDR", ":4443"), "web server address")
-	cert = flag.String("cert", env("CERT_FILE", ""), "path of TLS certificate file")
-	key  = flag.String("key", env("CERT_KEY", ""), "path of TLS private key file")
+	addr        = flag.String("addr", env("ADDR", ":4443"), "web server address")
+	cert        = flag.String("cert", env("CERT_FILE", ""), "path of TLS certificate file")
+	key         = flag.String("key", env("CERT_KEY", ""), "path of TLS private key file")
+	storageURL  = flag.String("storage-url", env("STORAGE_URL", "s3://"+os.Getenv("AWS_VOD_BUCKET")), "URL of video storage, e.g. s3://bucket, file:///var/lib/molpastream or mem://")
+	metadataURL = flag.String("metadata-url", env("METADATA_URL", "dynamodb://"+os.Getenv("AWS_VOD_DB_NAME")), "URL of video metadata, e.g. dynamodb://table, file:///var/lib/molpastream or mem://")
+	streamURL   = flag.String("stream-storage-url", env("STREAM_STORAGE_URL", "s3://"+os.Getenv("AWS_VOD_HLS_BUCKET")), "URL of the storage of transcoded streams")
+	signingKey  = flag.String("signing-key", env("SIGNING_KEY", ""), "secret key of signed playback URLs, playback is not protected if empty")
+	proxies     = flag.String("trusted-proxies", env("TRUSTED_PROXIES", ""), "comma-separated CIDRs of the load balancers whose X-Forwarded-For header identifies the clients of signed playback URLs")
+	transcode   = flag.String("transcoder", env("TRANSCODER", ""), "transcoder of uploaded videos, e.g. ffmpeg or mediaconvert, videos are transcoded by AWS lambda functions if empty")
+	ffmpegPath  = flag.String("ffmpeg-path", env("FFMPEG_PATH", "ffmpeg"), "path of ffmpeg binary used by ffmpeg transcoder")
+	jobSettings = flag.String("job-settings", env("JOB_SETTINGS", "aws/lambda/batch_transcode/job.json"), "path of job settings used by mediaconvert transcoder")
+
+	// The duration flags are defined by defineDurations, as their defaults in environment variables may be invalid.
+	sweepEvery, launchEvery, readTimeout, sendTimeout time.Duration
+)
+
+const (
+	// The number of transcoding jobs queued to the local transcoder.
+	transcodeQueueSize = 100
+	// The timeouts of slow clients, which apply whatever the size of request and response bodies.
+	readHeaderTimeout = 15 * time.Second
+	idleTimeout       = 2 * time.Minute
 )
 
 // Get the value of environment variables.
@@ -25,15 +56,131 @@ func env(key string, def string) string {
 	return def
 }
 
+// Create the transcoder submitting jobs to AWS Elemental MediaConvert, the source files are read from the given storage.
+// The buckets are the hosts of the storage URLs.
+func newMediaConvertTranscoder(sources repository.Downloader) (*transcoder.MediaConvertTranscoder, error) {
+	settings, err := os.ReadFile(*jobSettings)
+	if err != nil {
+		return nil, err
+	}
+	src, err := url.Parse(*storageURL)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := url.Parse(*streamURL)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(&aws.Config{Endpoint: aws.String(os.Getenv("AWS_VOD_MEDIACONVERT_URL"))})
+	if err != nil {
+		return nil, err
+	}
+	return transcoder.NewMediaConvertTranscoder(mediaconvert.New(sess), sources, transcoder.MediaConvertConfig{
+		Role:         os.Getenv("AWS_VOD_MEDIACONVERT_ROLE_ARN"),
+		Settings:     settings,
+		SourceBucket: src.Host,
+		StreamBucket: dst.Host,
+	}), nil
+}
+
+// Parse the comma-separated CIDRs of the trusted proxies.
+func trustedProxies(val string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(val, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Parse the duration in the environment variable, the default value is parsed if the variable is not set.
+func duration(key string, def string) (time.Duration, error) {
+	val := env(key, def)
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, val, err)
+	}
+	return d, nil
+}
+
+// Define the duration flags whose defaults are read from the environment variables.
+func defineDurations() error {
+	for _, f := range []struct {
+		p                     *time.Duration
+		name, key, def, usage string
+	}{
+		{&sweepEvery, "sweep-interval", "SWEEP_INTERVAL", "1h", "interval of sweeping expired uploads, disabled if zero"},
+		{&launchEvery, "transcode-interval", "TRANSCODE_INTERVAL", "10s", "interval of launching transcoding jobs of uploaded videos"},
+		{&readTimeout, "read-timeout", "READ_TIMEOUT", "0", "timeout of reading a request including its body, disabled if zero so that large uploads stream to storage"},
+		{&sendTimeout, "write-timeout", "WRITE_TIMEOUT", "0", "timeout of writing a response, disabled if zero so that large downloads stream to clients"},
+	} {
+		d, err := duration(f.key, f.def)
+		if err != nil {
+			return err
+		}
+		flag.DurationVar(f.p, f.name, d, f.usage)
+	}
+	return nil
+}
+
 func main() {
+	if err := defineDurations(); err != nil {
+		log.Fatalf("failed to read durations: %v", err)
+	}
 	flag.Parse()
+	storage, videos, err := persistence.Open(persistence.Config{StorageURL: *storageURL, MetadataURL: *metadataURL})
+	if err != nil {
+		log.Fatal(err)
+	}
+	streams, err := persistence.OpenStorage(*streamURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sweepEvery > 0 {
+		app.StartUploadSweeper(context.Background(), videos, storage, sweepEvery)
+	}
+	// The transcoder launches the jobs of the videos transcoded again on demand.
+	var tr repository.Transcoder
+	switch *transcode {
+	case "":
+	case "ffmpeg":
+		t := transcoder.NewFFmpegTranscoder(videos, storage, streams, *ffmpegPath, transcodeQueueSize)
+		go t.Run(context.Background())
+		app.StartTranscodeDispatcher(context.Background(), videos, t, launchEvery)
+		tr = t
+	case "mediaconvert":
+		// The uploaded videos are transcoded by AWS lambda functions, and the jobs report to the lambda functions.
+		if tr, err = newMediaConvertTranscoder(storage); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown transcoder %q", *transcode)
+	}
 	r := mux.NewRouter()
-	app.SetupRoutes(r)
+	var signer *urlsign.Signer
+	if *signingKey != "" {
+		signer = urlsign.NewSigner([]byte(*signingKey))
+	} else {
+		log.Printf("playback is not protected, set the signing key to require signed URLs")
+	}
+	trusted, err := trustedProxies(*proxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.SetupRoutes(r, videos, storage, streams, signer, trusted, tr)
 	srv := &http.Server{
-		Handler:      r,
-		Addr:         *addr,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		Handler:           r,
+		Addr:              *addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      sendTimeout,
+		IdleTimeout:       idleTimeout,
 	}
 	log.Printf("the server started on port: %s\n", *addr)
 	if *cert != "" && *key != "" {
@@ -3,11 +3,12 @@ package app
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/gorilla/mux"
-	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/urlsign"
 )
 
 type appHandler func(http.ResponseWriter, *http.Request) error
@@ -23,11 +24,32 @@ func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Register API endpoints to the router.
-func SetupRoutes(r *mux.Router) {
-	sess := session.Must(session.NewSession())
-	c := &controller{persistence.NewVideoRepository(sess), persistence.NewUploader(sess)}
+// Register API endpoints to the router, videos are kept in the given video repository and storage,
+// and the streams of transcoded videos are served from the given storage of streams.
+// Playback endpoints require the URLs signed by the signer, playback is not protected if the signer is nil.
+// The signed URLs bound to client IPs are verified against the X-Forwarded-For header set by the trusted proxies.
+// Videos are transcoded again on demand by the transcoder, which is disabled if the transcoder is nil.
+func SetupRoutes(r *mux.Router, videos repository.VideoRepository, storage repository.Storage, streams repository.Downloader, signer *urlsign.Signer, proxies []*net.IPNet, transcoder repository.Transcoder) {
+	c := &controller{videos, storage, storage}
+	sc := &streamController{videos, streams, signer}
+	playback := &playbackController{videos, signer}
+	tc := &transcodeController{videos, transcoder}
+	r.Methods("GET").Path("/molpastream/v1/videos").Handler(appHandler(c.listVideos))
 	r.Methods("GET").Path("/molpastream/v1/videos/{id}").Handler(appHandler(c.getVideo))
+	r.Methods("PATCH").Path("/molpastream/v1/videos/{id}").Handler(appHandler(c.updateVideo))
+	r.Methods("DELETE").Path("/molpastream/v1/videos/{id}").Handler(appHandler(c.deleteVideo))
+	r.Methods("POST").Path("/molpastream/v1/videos/{id}/playback").Handler(appHandler(playback.createPlayback))
+	r.Methods("POST").Path("/molpastream/v1/videos/{id}/transcode").Handler(appHandler(tc.retranscodeVideo))
+	r.Methods("GET").Path(mediaPathTemplate).Handler(signedURL(signer, proxies, appHandler(c.streamVideo)))
+	r.Methods("GET").Path(manifestPathTemplate).Handler(signedURL(signer, proxies, appHandler(sc.redirectManifest)))
+	r.Methods("GET").Path(streamPathTemplate).Handler(signedURL(signer, proxies, appHandler(sc.serveStream)))
 	r.Methods("POST").Path("/molpastream/v1/videos").Handler(appHandler(c.createVideo))
 	r.Methods("PUT").Path("/upload/molpastream/v1/videos/{id}").Handler(appHandler(c.uploadVideo))
+	r.Methods("DELETE").Path("/upload/molpastream/v1/videos/{id}").Handler(appHandler(c.cancelUpload))
+	// Endpoints of tus resumable upload protocol, see https://tus.io/protocols/resumable-upload.
+	r.Methods("OPTIONS").Path(tusUploadPath).Handler(tusResumable(appHandler(c.tusOptions)))
+	r.Methods("POST").Path(tusUploadPath).Handler(tusResumable(appHandler(c.tusCreateUpload)))
+	r.Methods("HEAD").Path(tusUploadPath + "/{id}").Handler(tusResumable(appHandler(c.tusUploadOffset)))
+	r.Methods("PATCH").Path(tusUploadPath + "/{id}").Handler(tusResumable(appHandler(c.tusPatchUpload)))
+	r.Methods("DELETE").Path(tusUploadPath + "/{id}").Handler(tusResumable(appHandler(c.cancelUpload)))
 }
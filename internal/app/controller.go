@@ -2,11 +2,19 @@ package app
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -18,11 +26,17 @@ import (
 const (
 	minUploadChunkSize = 256 << 10
 	maxUploadChunkSize = 10 << 20
+	maxUploadParts     = 10000
+	maxUploadSize      = maxUploadParts * maxUploadChunkSize
+	defaultListResults = 20
+	maxListResults     = 100
+	maxSaveAttempts    = 3
 )
 
 type controller struct {
 	video_repo repository.VideoRepository
 	uploader   repository.Uploader
+	downloader repository.Downloader
 }
 
 // Get a single video.
@@ -38,11 +52,131 @@ func (c *controller) getVideo(w http.ResponseWriter, r *http.Request) error {
 	if video == nil {
 		return &appError{http.StatusNotFound, "video ID does not exist"}
 	}
-	return replyJSON(w, VideoResponse{video.Id, video.Description, video.Tags, video.Metadata, video.Status}, http.StatusOK)
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	return replyJSON(w, newVideoResponse(video), http.StatusOK)
+}
+
+// List the videos filtered by status and tag, a page of videos is followed by the token of the next page.
+func (c *controller) listVideos(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	filter := repository.VideoFilter{Status: query.Get("status"), Tag: query.Get("tag")}
+	if filter.Status != "" && !entity.IsValidStatus(filter.Status) {
+		return &appError{http.StatusBadRequest, "invalid status of video"}
+	}
+	limit := defaultListResults
+	if query.Get("maxResults") != "" {
+		n, err := strconv.Atoi(query.Get("maxResults"))
+		if err != nil || n < 1 || n > maxListResults {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("maxResults must between 1 and %d", maxListResults)}
+		}
+		limit = n
+	}
+	videos, next, err := c.video_repo.List(filter, query.Get("pageToken"), limit)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return &appError{http.StatusBadRequest, "invalid pageToken"}
+	}
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	resp := VideoListResponse{Items: make([]VideoResponse, 0, len(videos)), NextPageToken: next}
+	for _, video := range videos {
+		resp.Items = append(resp.Items, newVideoResponse(video))
+	}
+	return replyJSON(w, resp, http.StatusOK)
+}
+
+// Update the title, description, tags and metadata of the video.
+func (c *controller) updateVideo(w http.ResponseWriter, r *http.Request) error {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		return &appError{http.StatusBadRequest, "video ID must be required"}
+	}
+	var data VideoPatchRequest
+	if err := parseJSON(w, r, &data); err != nil {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot parse JSON from request body: %v", err)}
+	}
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	if data.Title != nil {
+		video.Title = *data.Title
+	}
+	if data.Description != nil {
+		video.Description = *data.Description
+	}
+	if data.Tags != nil {
+		video.Tags = *data.Tags
+	}
+	if data.Metadata != nil {
+		video.Metadata = *data.Metadata
+	}
+	err = c.video_repo.Update(video)
+	if errors.Is(err, repository.ErrVideoNotFound) {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	return replyJSON(w, newVideoResponse(video), http.StatusOK)
+}
+
+// Delete the video softly, the video is marked as deleted and its upload in progress is aborted.
+func (c *controller) deleteVideo(w http.ResponseWriter, r *http.Request) error {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		return &appError{http.StatusBadRequest, "video ID must be required"}
+	}
+	// The latest video is deleted again if it was modified concurrently, each upload is aborted once.
+	var aborted string
+	for attempt := 1; ; attempt++ {
+		video, err := c.video_repo.GetById(id)
+		if err != nil {
+			return &appError{http.StatusInternalServerError, err.Error()}
+		}
+		if video == nil {
+			return &appError{http.StatusNotFound, "video ID does not exist"}
+		}
+		// Deleting a video more than once has no effect.
+		if video.Status == entity.StatusDeleted {
+			break
+		}
+		if video.Status == entity.StatusUploading && video.Upload.Id != aborted {
+			if err = c.uploader.AbortMultipart(video.Id, video.Upload.Id); err != nil {
+				return &appError{http.StatusInternalServerError, err.Error()}
+			}
+			aborted = video.Upload.Id
+		}
+		err = c.video_repo.Delete(video.Id)
+		if err == nil {
+			break
+		}
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) {
+			return &appError{http.StatusInternalServerError, err.Error()}
+		}
+		if attempt == maxSaveAttempts {
+			return &appError{http.StatusConflict, err.Error()}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 // Create a new video.
 func (c *controller) createVideo(w http.ResponseWriter, r *http.Request) error {
+	// The metadata and the media are uploaded in one request by multipart upload.
+	if r.URL.Query().Get("uploadType") == "multipart" {
+		return c.createMultipartVideo(w, r)
+	}
 	var data VideoRequest
 	if err := parseJSON(w, r, &data); err != nil {
 		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot parse JSON from request body: %v", err)}
@@ -54,6 +188,15 @@ func (c *controller) createVideo(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return &appError{http.StatusBadRequest, "X-Upload-Content-Length header must be required"}
 	}
+	// The SHA-256 checksum of the entire file is verified once the upload is completed.
+	expected, err := parseChecksum(r.Header)
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	profile, err := transcodeProfile(data.Profile)
+	if err != nil {
+		return err
+	}
 	// Create a new video entity for persistence data store.
 	video := entity.NewVideo(
 		uuid.New().String(),
@@ -64,14 +207,25 @@ func (c *controller) createVideo(w http.ResponseWriter, r *http.Request) error {
 		data.Tags,
 		data.Metadata,
 	)
+	video.Profile = profile
+	video.ExpectedSHA256 = expected.SHA256
 	switch r.URL.Query().Get("uploadType") {
 	case "media":
 	case "resumable":
+		// Part numbers are derived from the chunk offset, which limits the size of resumable upload.
+		if size > maxUploadSize {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("size must not exceed %d bytes", int64(maxUploadSize))}
+		}
 		uploadId, err := c.uploader.CreateMultipart(video.Id)
 		if err != nil {
 			return &appError{http.StatusInternalServerError, err.Error()}
 		}
-		video.NewUpload(uploadId)
+		video.NewUpload(uploadId, time.Now().Add(entity.UploadSessionTTL))
+		video.Upload.ChunkSize = uploadChunkSize(size)
+		if err = transition(video, entity.StatusUploading); err != nil {
+			return err
+		}
+		w.Header().Set("X-Upload-Chunk-Granularity", strconv.FormatInt(video.Upload.ChunkSize, 10))
 	default:
 		return &appError{http.StatusBadRequest, "Invalid upload type"}
 	}
@@ -79,7 +233,86 @@ func (c *controller) createVideo(w http.ResponseWriter, r *http.Request) error {
 	if err = c.video_repo.Save(video); err != nil {
 		return &appError{http.StatusInternalServerError, err.Error()}
 	}
-	return replyJSON(w, VideoResponse{video.Id, video.Description, video.Tags, video.Metadata, video.Status}, http.StatusCreated)
+	return replyJSON(w, newVideoResponse(video), http.StatusCreated)
+}
+
+// Create a new video from the multipart/related request body, which consists of the JSON metadata part and the media part.
+// The media part is read into memory to know its size, so multipart upload is limited to small files.
+func (c *controller) createMultipartVideo(w http.ResponseWriter, r *http.Request) error {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		return &appError{http.StatusBadRequest, "Content-Type must be multipart/related"}
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	// The first part is the metadata of the video.
+	p, err := mr.NextPart()
+	if err != nil {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot read metadata part: %v", err)}
+	}
+	if t, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); t != "application/json" {
+		return &appError{http.StatusBadRequest, "Content-Type of metadata part must be application/json"}
+	}
+	var data VideoRequest
+	if err = json.NewDecoder(p).Decode(&data); err != nil {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot parse JSON from metadata part: %v", err)}
+	}
+	profile, err := transcodeProfile(data.Profile)
+	if err != nil {
+		return err
+	}
+	// The second part is the media of the video.
+	if p, err = mr.NextPart(); err != nil {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot read media part: %v", err)}
+	}
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = r.Header.Get("X-Upload-Content-Type")
+	}
+	if contentType == "" {
+		return &appError{http.StatusBadRequest, "Content-Type of media part must be required"}
+	}
+	checksum, err := parseChecksum(http.Header(p.Header))
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	expected, err := parseChecksum(r.Header)
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	media, err := io.ReadAll(io.LimitReader(p, maxUploadChunkSize+1))
+	if err != nil {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot read media part: %v", err)}
+	}
+	if len(media) == 0 {
+		return &appError{http.StatusBadRequest, "size must be greater than 0 bytes"}
+	}
+	if len(media) > maxUploadChunkSize {
+		return &appError{http.StatusRequestEntityTooLarge, fmt.Sprintf("size must not exceed %d bytes", maxUploadChunkSize)}
+	}
+	video := entity.NewVideo(
+		uuid.New().String(),
+		data.Title,
+		data.Description,
+		contentType,
+		int64(len(media)),
+		data.Tags,
+		data.Metadata,
+	)
+	video.Profile = profile
+	video.ExpectedSHA256 = expected.SHA256
+	err = c.uploader.SimpleUpload(video.Id, bytes.NewReader(media), video.Size, checksum)
+	if errors.Is(err, repository.ErrChecksumMismatch) {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	sum := sha256.Sum256(media)
+	video.SHA256 = base64.StdEncoding.EncodeToString(sum[:])
+	if err = c.completeUpload(video); err != nil {
+		return err
+	}
+	return replyJSON(w, newVideoResponse(video), http.StatusCreated)
 }
 
 // Upload the video to the remote storage.
@@ -88,18 +321,46 @@ func (c *controller) uploadVideo(w http.ResponseWriter, r *http.Request) error {
 	if id == "" {
 		return &appError{http.StatusBadRequest, "video ID must be required"}
 	}
+	// Query the upload status if the Content-Range header omits the byte range.
+	if h := r.Header.Get("Content-Range"); h != "" {
+		if cr, err := httprange.ParseContentRange(h); err == nil && cr.IsUnspecified() {
+			return c.getUploadStatus(w, id, cr)
+		}
+	}
 	// Get the partial size of video upload.
 	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
 		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot parse Content-Length header: %v", err)}
 	}
-	if size < minUploadChunkSize || size > maxUploadChunkSize {
-		return &appError{http.StatusBadRequest, fmt.Sprintf("size must between %d and %d bytes", minUploadChunkSize, maxUploadChunkSize)}
+	// Parse the Content-Range header for resumable upload.
+	var cr *httprange.ContentRange
+	if r.Header.Get("Content-Range") != "" {
+		cr, err = httprange.ParseContentRange(r.Header.Get("Content-Range"))
+		if err != nil {
+			return &appError{http.StatusBadRequest, err.Error()}
+		}
+		if cr.Start > cr.End || cr.End >= cr.Size {
+			return &appError{http.StatusBadRequest, "invalid range of Content-Range header"}
+		}
 	}
-	if size%minUploadChunkSize > 0 {
-		return &appError{http.StatusBadRequest, fmt.Sprintf("size must be the multiple of %d bytes", minUploadChunkSize)}
+	if size <= 0 {
+		return &appError{http.StatusBadRequest, "size must be greater than 0 bytes"}
+	}
+	checksum, err := parseChecksum(r.Header)
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	// Validate the chunk size of resumable upload.
+	// The last chunk is allowed to be smaller than the chunk size.
+	if r.URL.Query().Get("uploadType") == "resumable" {
+		isLastChunk := cr != nil && cr.IsLastByte()
+		if size > maxUploadChunkSize || size < minUploadChunkSize && !isLastChunk {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("size must between %d and %d bytes", minUploadChunkSize, maxUploadChunkSize)}
+		}
+		if size%minUploadChunkSize > 0 && !isLastChunk {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("size must be the multiple of %d bytes", minUploadChunkSize)}
+		}
 	}
-	var cr *httprange.ContentRange
 	video, err := c.video_repo.GetById(id)
 	if err != nil {
 		return &appError{http.StatusInternalServerError, err.Error()}
@@ -107,12 +368,10 @@ func (c *controller) uploadVideo(w http.ResponseWriter, r *http.Request) error {
 	if video == nil {
 		return &appError{http.StatusNotFound, "video ID does not exist"}
 	}
-	// Parse the Content-Range header for resumable upload.
-	if r.Header.Get("Content-Range") != "" {
-		cr, err = httprange.ParseContentRange(r.Header.Get("Content-Range"))
-		if err != nil {
-			return &appError{http.StatusInternalServerError, err.Error()}
-		}
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	if cr != nil {
 		if cr.Length() != size {
 			return &appError{http.StatusBadRequest, "invalid length of Content-Range header"}
 		}
@@ -120,49 +379,63 @@ func (c *controller) uploadVideo(w http.ResponseWriter, r *http.Request) error {
 			return &appError{http.StatusBadRequest, "invalid size of Content-Range header"}
 		}
 	}
-	buf := new(bytes.Buffer)
-	body := http.MaxBytesReader(w, r.Body, maxUploadChunkSize)
-	if _, err = io.Copy(buf, body); err != nil {
-		return &appError{http.StatusInternalServerError, err.Error()}
-	}
 	// Upload the video file by the given upload type.
 	// - media: Simple upload. Use this type to quickly transfer small media file to the remote storage.
 	// - resumable: Resumable upload. Use this type for large files when there's a high chance fo network interruption.
 	switch r.URL.Query().Get("uploadType") {
 	case "media":
-		err = c.uploader.SimpleUpload(id, buf.Bytes())
+		if video.Status != entity.StatusCreated {
+			return &appError{http.StatusConflict, fmt.Sprintf("video cannot be uploaded in %s status", video.Status)}
+		}
+		// The file is served by the size declared when the video was created.
+		if size != video.Size {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("Content-Length must be the size of the video (%d bytes)", video.Size)}
+		}
+		h := sha256.New()
+		err = c.uploader.SimpleUpload(id, io.TeeReader(r.Body, h), size, checksum)
+		if errors.Is(err, repository.ErrChecksumMismatch) {
+			return &appError{http.StatusBadRequest, err.Error()}
+		}
 		if err != nil {
 			return &appError{http.StatusInternalServerError, err.Error()}
 		}
-		video.SetStatus(entity.UploadedStatusCompleted)
-		if err = c.video_repo.Save(video); err != nil {
-			return &appError{http.StatusInternalServerError, err.Error()}
+		video.SHA256 = entity.EncodeSHA256(h)
+		if err = c.completeUpload(video); err != nil {
+			return err
 		}
 	case "resumable":
 		if cr == nil {
 			return &appError{http.StatusBadRequest, "Content-Range must be required"}
 		}
-		part, err := c.uploader.UploadPart(id, video.Upload.Id, buf.Bytes(), size, cr.CurrentPart())
-		if err != nil {
-			return &appError{http.StatusInternalServerError, err.Error()}
+		if video.Upload == nil {
+			return &appError{http.StatusBadRequest, "video is not uploaded by resumable upload"}
 		}
-		video.AddUploadPart(part)
-		// Assemble uploaded parts and complete the upload.
-		if len(video.Upload.Parts) >= int(cr.Parts()) {
-			video.SetStatus(entity.UploadedStatusCompleted)
-			if err = c.uploader.CompleteMultipart(id, video.Upload.Id, video.Upload.Parts); err != nil {
-				return &appError{http.StatusInternalServerError, err.Error()}
-			}
+		if video.IsUploadExpired(time.Now()) {
+			return &appError{http.StatusGone, "upload session has expired"}
 		}
-		if err = c.video_repo.Save(video); err != nil {
-			return &appError{http.StatusInternalServerError, err.Error()}
+		if video.Status != entity.StatusUploading {
+			return &appError{http.StatusConflict, fmt.Sprintf("video cannot be uploaded in %s status", video.Status)}
+		}
+		chunkSize := sessionChunkSize(video.Upload)
+		if cr.Start%chunkSize > 0 {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("start of Content-Range must be the multiple of %d bytes", chunkSize)}
+		}
+		if size%chunkSize > 0 && !cr.IsLastByte() {
+			return &appError{http.StatusBadRequest, fmt.Sprintf("size must be the multiple of %d bytes", chunkSize)}
+		}
+		if video.Upload.Overlaps(cr.Start, size) {
+			return &appError{http.StatusConflict, "Content-Range overlaps the uploaded parts"}
+		}
+		if video, err = c.uploadPart(video, cr, http.MaxBytesReader(w, r.Body, maxUploadChunkSize), checksum); err != nil {
+			return err
 		}
 	default:
 		return &appError{http.StatusBadRequest, "Invalid upload type"}
 	}
 	// Respond to the client if the upload was not completed,
 	// otherwise respond in success when the given file has been uploaded.
-	if cr != nil && len(video.Upload.Parts) < int(cr.Parts()) {
+	if cr != nil && !video.IsUploaded() {
+		setUploadRange(w, video.Upload)
 		w.WriteHeader(http.StatusPartialContent)
 	} else {
 		w.WriteHeader(http.StatusOK)
@@ -170,6 +443,402 @@ func (c *controller) uploadVideo(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Upload a chunk of resumable upload as the part keyed by its byte offset.
+// Parts can be uploaded in any order or in parallel, the upload is completed once all bytes are received.
+func (c *controller) uploadPart(video *entity.Video, cr *httprange.ContentRange, body io.Reader, checksum entity.Checksum) (*entity.Video, error) {
+	// Hash the chunk if it continues the running digest of the file.
+	var h hash.Hash
+	digest := video.Upload.ResumableDigest()
+	if digest.Offset == cr.Start {
+		var err error
+		if h, err = digest.Resume(); err != nil {
+			return nil, &appError{http.StatusInternalServerError, err.Error()}
+		}
+		body = io.TeeReader(body, h)
+	}
+	// Part numbers follow the byte offset, so that parts are assembled in order.
+	part, err := c.uploader.UploadPart(video.Id, video.Upload.Id, body, cr.Length(), cr.Start/sessionChunkSize(video.Upload)+1, checksum)
+	if errors.Is(err, repository.ErrChecksumMismatch) {
+		return nil, &appError{http.StatusBadRequest, err.Error()}
+	}
+	if err != nil {
+		return nil, &appError{http.StatusInternalServerError, err.Error()}
+	}
+	part.Offset, part.Size = cr.Start, cr.Length()
+	if h == nil {
+		digest = nil
+	} else if digest, err = digest.Advance(h, part); err != nil {
+		return nil, &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video, err = c.addUploadParts(video, []*entity.Part{part}, digest); err != nil {
+		return nil, err
+	}
+	return c.completeMultipart(video)
+}
+
+// Add the uploaded parts to the persistence, and save the running digest if it was advanced by the parts.
+func (c *controller) addUploadParts(video *entity.Video, parts []*entity.Part, digest *entity.Digest) (*entity.Video, error) {
+	var err error
+	for _, part := range parts {
+		// Atomically add the part to the persistence for concurrent uploads of the same video.
+		if video, err = c.video_repo.AddUploadPart(video.Id, video.Upload.Id, part); err != nil {
+			return nil, &appError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+	if digest != nil {
+		if video, err = c.video_repo.SaveUploadDigest(video.Id, video.Upload.Id, digest); err != nil {
+			return nil, &appError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+	return video, nil
+}
+
+// Complete the upload once all bytes of the video are received. The final chunks uploaded in parallel may all see the
+// entire file, so the completion is claimed by marking the video as uploaded on condition of its version, and only
+// the claimer assembles the parts. The latest video is returned.
+func (c *controller) completeMultipart(video *entity.Video) (*entity.Video, error) {
+	for attempt := 1; video.IsUploaded() && video.Status == entity.StatusUploading; attempt++ {
+		if err := transition(video, entity.StatusUploaded); err != nil {
+			return nil, err
+		}
+		err := c.video_repo.Save(video)
+		if err == nil {
+			return video, c.assembleParts(video)
+		}
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) {
+			return nil, &appError{http.StatusInternalServerError, err.Error()}
+		}
+		if attempt == maxSaveAttempts {
+			return nil, &appError{http.StatusConflict, err.Error()}
+		}
+		latest, err := c.video_repo.GetById(video.Id)
+		if err != nil {
+			return nil, &appError{http.StatusInternalServerError, err.Error()}
+		}
+		if latest == nil {
+			return nil, &appError{http.StatusNotFound, "video ID does not exist"}
+		}
+		video = latest
+	}
+	return video, nil
+}
+
+// Assemble the parts of the video claimed as uploaded, and mark the video as failed if the checksum of the uploaded
+// file mismatches the expected checksum. The video is marked as failed if the parts can never be assembled,
+// otherwise the claim is released, so that the final chunk is uploaded again to retry the completion.
+func (c *controller) assembleParts(video *entity.Video) error {
+	if err := c.uploader.CompleteMultipart(video.Id, video.Upload.Id, video.Upload.SortedParts()); err != nil {
+		status, code := entity.StatusUploading, http.StatusServiceUnavailable
+		if errors.Is(err, repository.ErrInvalidParts) {
+			status, code = entity.StatusFailed, http.StatusInternalServerError
+		}
+		if serr := saveVideo(c.video_repo, video, func(video *entity.Video) error {
+			return transition(video, status)
+		}); serr != nil {
+			return serr
+		}
+		return &appError{code, err.Error()}
+	}
+	sha256, err := c.uploadedSHA256(video)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	mismatched := video.ExpectedSHA256 != "" && video.ExpectedSHA256 != sha256
+	err = saveVideo(c.video_repo, video, func(video *entity.Video) error {
+		video.SHA256 = sha256
+		if mismatched {
+			return transition(video, entity.StatusFailed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mismatched {
+		return &appError{http.StatusBadRequest, "checksum of the uploaded file mismatched"}
+	}
+	return nil
+}
+
+// Get the SHA-256 checksum of the file uploaded by multipart upload.
+// The bytes not covered by the running digest are read back from the storage if the checksum is expected,
+// otherwise the checksum is left empty.
+func (c *controller) uploadedSHA256(video *entity.Video) (string, error) {
+	digest := video.Upload.ResumableDigest()
+	if digest.Offset < video.Size && video.ExpectedSHA256 == "" {
+		return "", nil
+	}
+	h, err := digest.Resume()
+	if err != nil {
+		return "", err
+	}
+	if digest.Offset < video.Size {
+		body, err := c.downloader.Download(video.Id, digest.Offset, video.Size-digest.Offset)
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err = io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	return entity.EncodeSHA256(h), nil
+}
+
+// Mark the video as uploaded, or failed if the checksum of the uploaded file mismatches the expected checksum.
+func (c *controller) completeUpload(video *entity.Video) error {
+	status := entity.StatusUploaded
+	mismatched := video.ExpectedSHA256 != "" && video.ExpectedSHA256 != video.SHA256
+	if mismatched {
+		status = entity.StatusFailed
+	}
+	sha256 := video.SHA256
+	err := saveVideo(c.video_repo, video, func(video *entity.Video) error {
+		video.SHA256 = sha256
+		return transition(video, status)
+	})
+	if err != nil {
+		return err
+	}
+	if mismatched {
+		return &appError{http.StatusBadRequest, "checksum of the uploaded file mismatched"}
+	}
+	return nil
+}
+
+// Apply the change to the video and save it. If the video was modified concurrently,
+// the change is applied again to the latest video until the attempts are exhausted.
+func saveVideo(videos repository.VideoRepository, video *entity.Video, change func(*entity.Video) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := change(video); err != nil {
+			return err
+		}
+		err := videos.Save(video)
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) {
+			if err != nil {
+				return &appError{http.StatusInternalServerError, err.Error()}
+			}
+			return nil
+		}
+		if attempt == maxSaveAttempts {
+			return &appError{http.StatusConflict, err.Error()}
+		}
+		latest, err := videos.GetById(video.Id)
+		if err != nil {
+			return &appError{http.StatusInternalServerError, err.Error()}
+		}
+		if latest == nil {
+			return &appError{http.StatusNotFound, "video ID does not exist"}
+		}
+		*video = *latest
+	}
+}
+
+// Transition the video to the given status, the illegal transition is responded as a conflict.
+func transition(video *entity.Video, status string) error {
+	if err := video.Transition(status, time.Now()); err != nil {
+		return &appError{http.StatusConflict, err.Error()}
+	}
+	return nil
+}
+
+// Get the status of resumable upload, respond the range of bytes the server has received.
+func (c *controller) getUploadStatus(w http.ResponseWriter, id string, cr *httprange.ContentRange) error {
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	if video.Upload == nil {
+		return &appError{http.StatusBadRequest, "video is not uploaded by resumable upload"}
+	}
+	if cr.Size != video.Size {
+		return &appError{http.StatusBadRequest, "invalid size of Content-Range header"}
+	}
+	if video.IsUploadExpired(time.Now()) {
+		return &appError{http.StatusGone, "upload session has expired"}
+	}
+	if video.Status != entity.StatusUploading {
+		return replyJSON(w, newVideoResponse(video), http.StatusOK)
+	}
+	// Respond with 308 Resume Incomplete to tell the client to continue the upload.
+	setUploadRange(w, video.Upload)
+	w.WriteHeader(http.StatusPermanentRedirect)
+	return nil
+}
+
+// Cancel the resumable upload, the uploaded parts are deleted and the video is marked as deleted.
+func (c *controller) cancelUpload(w http.ResponseWriter, r *http.Request) error {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		return &appError{http.StatusBadRequest, "video ID must be required"}
+	}
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Upload == nil {
+		return &appError{http.StatusBadRequest, "video is not uploaded by resumable upload"}
+	}
+	if video.IsAvailable() {
+		return &appError{http.StatusConflict, "upload has been completed"}
+	}
+	// Cancelling an upload more than once has no effect.
+	if video.Status != entity.StatusDeleted {
+		// The upload has been aborted if it failed.
+		if video.Status == entity.StatusUploading {
+			if err = c.uploader.AbortMultipart(video.Id, video.Upload.Id); err != nil {
+				return &appError{http.StatusInternalServerError, err.Error()}
+			}
+		}
+		err = saveVideo(c.video_repo, video, func(video *entity.Video) error {
+			return transition(video, entity.StatusDeleted)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Stream the video content to the client, supporting byte-range requests.
+func (c *controller) streamVideo(w http.ResponseWriter, r *http.Request) error {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		return &appError{http.StatusBadRequest, "video ID must be required"}
+	}
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if !video.IsAvailable() {
+		return &appError{http.StatusNotFound, "video content is not available"}
+	}
+	return serveRanges(w, r, video.Size, video.ContentType, func(start, length int64) (io.ReadCloser, error) {
+		return c.downloader.Download(video.Id, start, length)
+	})
+}
+
+// Serve the file of the given size to the client, supporting byte-range requests.
+func serveRanges(w http.ResponseWriter, r *http.Request, size int64, contentType string, src httprange.SourceFunc) error {
+	ranges, err := httprange.ParseRange(r.Header.Get("Range"), size)
+	if errors.Is(err, httprange.ErrUnsatisfiable) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return &appError{http.StatusRequestedRangeNotSatisfiable, err.Error()}
+	}
+	// The malformed Range header and the units other than bytes are ignored.
+	if err != nil {
+		ranges = nil
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	// Serve the entire file if no range was requested or the ranges are larger than the file.
+	if len(ranges) == 0 || httprange.SumLength(ranges) > size {
+		return writeRange(w, httprange.Range{Start: 0, Length: size}, contentType, src, http.StatusOK)
+	}
+	if len(ranges) == 1 {
+		w.Header().Set("Content-Range", ranges[0].ContentRange(size))
+		return writeRange(w, ranges[0], contentType, src, http.StatusPartialContent)
+	}
+	// Respond the multipart/byteranges body for multiple ranges.
+	mw := httprange.NewMultipartWriter(ranges, contentType, size)
+	w.Header().Set("Content-Type", mw.ContentType())
+	w.Header().Set("Content-Length", strconv.FormatInt(mw.Length(), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	return mw.Write(w, src)
+}
+
+// Write a byte range of the file to the client.
+func writeRange(w http.ResponseWriter, ra httprange.Range, contentType string, src httprange.SourceFunc, code int) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(ra.Length, 10))
+	if ra.Length == 0 {
+		w.WriteHeader(code)
+		return nil
+	}
+	body, err := src(ra.Start, ra.Length)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	defer body.Close()
+	w.WriteHeader(code)
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// Get the name of the transcode profile given by the client, the default profile is used if the name is empty.
+func transcodeProfile(name string) (string, error) {
+	p, ok := entity.GetProfile(name)
+	if !ok {
+		return "", &appError{http.StatusBadRequest, fmt.Sprintf("unknown transcode profile %q", name)}
+	}
+	return p.Name, nil
+}
+
+// Get the chunk size of the upload of the given size, the smallest multiple of the minimum chunk size
+// whose parts cover the size within the parts limit. Each chunk is uploaded as a part of the multipart upload,
+// so the chunk size is at least the minimum part size of the storage.
+func uploadChunkSize(size int64) int64 {
+	parts := (size + maxUploadParts - 1) / maxUploadParts
+	n := (parts + minUploadChunkSize - 1) / minUploadChunkSize * minUploadChunkSize
+	if n < repository.MinPartSize {
+		return repository.MinPartSize
+	}
+	return n
+}
+
+// Get the chunk size of the upload session, the sessions created without the chunk size are chunked by the minimum size.
+func sessionChunkSize(u *entity.UploadProgress) int64 {
+	if u.ChunkSize == 0 {
+		return minUploadChunkSize
+	}
+	return u.ChunkSize
+}
+
+// Parse the base64 encoded checksums of the content from the Content-MD5 and X-Upload-Checksum-SHA256 headers.
+func parseChecksum(h http.Header) (entity.Checksum, error) {
+	checksum := entity.Checksum{MD5: h.Get("Content-MD5"), SHA256: h.Get("X-Upload-Checksum-SHA256")}
+	if !isDigest(checksum.MD5, md5.Size) {
+		return checksum, errors.New("invalid Content-MD5 header")
+	}
+	if !isDigest(checksum.SHA256, sha256.Size) {
+		return checksum, errors.New("invalid X-Upload-Checksum-SHA256 header")
+	}
+	return checksum, nil
+}
+
+// Determine whether the value is empty or a base64 encoded digest of the given size.
+func isDigest(s string, size int) bool {
+	if s == "" {
+		return true
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(b) == size
+}
+
+// Set the Range header with the bytes that have been uploaded to the storage.
+func setUploadRange(w http.ResponseWriter, upload *entity.UploadProgress) {
+	if upload == nil {
+		return
+	}
+	if n := upload.Received(); n > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	}
+}
+
 // Parse incoming request body as JSON object.
 func parseJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
@@ -2,14 +2,25 @@ package app
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/httprange"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
 )
 
 func TestGetVideo(t *testing.T) {
@@ -21,6 +32,7 @@ func TestGetVideo(t *testing.T) {
 	}{
 		{"/molpastream/v1/videos", map[string]string{}, nil, errors.New("video ID must be required")},
 		{"/molpastream/v1/videos/1", map[string]string{"id": "1"}, nil, errors.New("video ID does not exist")},
+		{"/molpastream/v1/videos/1", map[string]string{"id": "1"}, &entity.Video{Status: entity.StatusDeleted}, errors.New("video has been deleted")},
 		{"/molpastream/v1/videos/1", map[string]string{"id": "1"}, &entity.Video{}, nil},
 	}
 	for _, tt := range tests {
@@ -30,7 +42,7 @@ func TestGetVideo(t *testing.T) {
 		}
 		r = mux.SetURLVars(r, tt.vars)
 		w := httptest.NewRecorder()
-		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}}
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{}}
 		err = c.getVideo(w, r)
 		if !errors.Is(err, tt.expectedErr) {
 			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
@@ -38,6 +50,162 @@ func TestGetVideo(t *testing.T) {
 	}
 }
 
+func TestVideoResponse(t *testing.T) {
+	now := time.Now()
+	video := &entity.Video{Id: "1", Title: "foo", ContentType: "video/mp4", Size: 1048576, Status: entity.StatusUploading, CreatedAt: now, UpdatedAt: now}
+	video.NewUpload("1", now.Add(time.Hour))
+	video.AddUploadPart(&entity.Part{Offset: 0, Size: 524288})
+	tests := []struct {
+		video    *entity.Video
+		expected VideoResponse
+	}{
+		{video, VideoResponse{Id: "1", Title: "foo", ContentType: "video/mp4", Size: 1048576, Status: entity.StatusUploading, Upload: UploadResponse{524288, 1048576}, CreatedAt: now, UpdatedAt: now}},
+		{&entity.Video{Id: "2", Size: 100, Status: entity.StatusUploaded, UploadedAt: now}, VideoResponse{Id: "2", Size: 100, Status: entity.StatusUploaded, Upload: UploadResponse{100, 100}, UploadedAt: &now}},
+		{&entity.Video{Id: "4", Size: 100, Status: entity.StatusReady, HLS: &entity.Stream{Bucket: "bucket", Playlist: "4.m3u8", Renditions: []*entity.Rendition{{Width: 640, Height: 360, Bitrate: 1200000, Codec: "H_264", Playlist: "4_360.m3u8"}, {Bitrate: 96000, Codec: "AAC", Playlist: "4_audio.m3u8"}}}, DASH: &entity.Stream{Bucket: "bucket", Playlist: "4.mpd", Renditions: []*entity.Rendition{{Width: 640, Height: 360, Bitrate: 1200000, Codec: "H_264"}}}}, VideoResponse{Id: "4", Size: 100, Status: entity.StatusReady, Upload: UploadResponse{100, 100}, HLS: &StreamResponse{"/molpastream/v1/videos/4/hls/4.m3u8", []RenditionResponse{{640, 360, 1200000, "H_264", "/molpastream/v1/videos/4/hls/4_360.m3u8"}, {0, 0, 96000, "AAC", "/molpastream/v1/videos/4/hls/4_audio.m3u8"}}}, DASH: &StreamResponse{"/molpastream/v1/videos/4/dash/4.mpd", []RenditionResponse{{640, 360, 1200000, "H_264", ""}}}}},
+		{&entity.Video{Id: "3", Size: 100, Status: entity.StatusFailed, Transcode: &entity.TranscodeJob{Id: "1", ErrorCode: 1010, ErrorMessage: "foo", StartedAt: now, FinishedAt: now}}, VideoResponse{Id: "3", Size: 100, Status: entity.StatusFailed, Upload: UploadResponse{0, 100}, Transcode: &TranscodeResponse{JobId: "1", ErrorCode: 1010, ErrorMessage: "foo", StartedAt: now, FinishedAt: &now}}},
+	}
+	for _, tt := range tests {
+		if resp := newVideoResponse(tt.video); !reflect.DeepEqual(resp, tt.expected) {
+			t.Errorf("expected response (%+v), got response (%+v)", tt.expected, resp)
+		}
+	}
+}
+
+func TestListVideos(t *testing.T) {
+	tests := []struct {
+		query         string
+		video         *entity.Video
+		expectedItems int
+		expectedErr   error
+	}{
+		{"status=foo", nil, 0, errors.New("invalid status of video")},
+		{"maxResults=0", nil, 0, fmt.Errorf("maxResults must between 1 and %d", maxListResults)},
+		{"maxResults=101", nil, 0, fmt.Errorf("maxResults must between 1 and %d", maxListResults)},
+		{"pageToken=foo", nil, 0, errors.New("invalid pageToken")},
+		{"", nil, 0, nil},
+		{"status=UPLOADED&tag=foo&maxResults=10&pageToken=1", &entity.Video{Id: "1"}, 1, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/molpastream/v1/videos?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{}}
+		err = c.listVideos(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		var resp VideoListResponse
+		if err = json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Items) != tt.expectedItems {
+			t.Errorf("expected %d items, got %d items", tt.expectedItems, len(resp.Items))
+		}
+	}
+}
+
+func TestUpdateVideo(t *testing.T) {
+	tests := []struct {
+		body        string
+		video       *entity.Video
+		expected    *entity.Video
+		expectedErr error
+	}{
+		{"{}", nil, nil, errors.New("video ID does not exist")},
+		{"{}", &entity.Video{Status: entity.StatusDeleted}, nil, errors.New("video has been deleted")},
+		{"foo", &entity.Video{}, nil, errors.New("cannot parse JSON from request body: invalid character 'o' in literal false (expecting 'a')")},
+		{`{"title": "foo"}`, &entity.Video{Description: "bar", Tags: []string{"baz"}}, &entity.Video{Title: "foo", Description: "bar", Tags: []string{"baz"}}, nil},
+		{`{"description": "", "tags": [], "metadata": {"foo": "bar"}}`, &entity.Video{Title: "foo", Description: "bar", Tags: []string{"baz"}}, &entity.Video{Title: "foo", Tags: []string{}, Metadata: map[string]string{"foo": "bar"}}, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("PATCH", "/molpastream/v1/videos/1", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		repo := &mockVideoRepoistory{tt.video}
+		c := &controller{repo, &mockUploader{}, &mockDownloader{}}
+		err = c.updateVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(repo.video, tt.expected) {
+			t.Errorf("expected video (%+v), got video (%+v)", tt.expected, repo.video)
+		}
+	}
+}
+
+func TestDeleteVideo(t *testing.T) {
+	tests := []struct {
+		video           *entity.Video
+		expectedAborted int
+		expectedErr     error
+	}{
+		{nil, 0, errors.New("video ID does not exist")},
+		{&entity.Video{Status: entity.StatusUploaded, Upload: &entity.UploadProgress{Id: "1"}}, 0, nil},
+		{&entity.Video{Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, 1, nil},
+		{&entity.Video{Status: entity.StatusDeleted}, 0, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("DELETE", "/molpastream/v1/videos/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		repo, uploader := &mockVideoRepoistory{tt.video}, &mockUploader{}
+		c := &controller{repo, uploader, &mockDownloader{}}
+		err = c.deleteVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if len(uploader.aborted) != tt.expectedAborted {
+			t.Errorf("expected %d aborted uploads, got %d", tt.expectedAborted, len(uploader.aborted))
+		}
+		if repo.video.Status != entity.StatusDeleted {
+			t.Errorf("expected status (%s), got status (%s)", entity.StatusDeleted, repo.video.Status)
+		}
+	}
+}
+
+func TestDeleteVideoConflict(t *testing.T) {
+	tests := []struct {
+		conflicts   int
+		expectedErr error
+	}{
+		{0, nil},
+		{maxSaveAttempts - 1, nil},
+		{maxSaveAttempts, &repository.ConflictError{Id: "1"}},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}
+		repo, uploader := &mockConflictVideoRepository{mockVideoRepoistory{video}, tt.conflicts}, &mockUploader{}
+		c := &controller{repo, uploader, &mockDownloader{}}
+		r, _ := http.NewRequest("DELETE", "/molpastream/v1/videos/1", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		err := c.deleteVideo(httptest.NewRecorder(), r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %d conflicts, got error (%v)", tt.expectedErr, tt.conflicts, err)
+		}
+		if err != nil {
+			continue
+		}
+		// The video is deleted once the status read is still the latest status, and the upload is aborted once.
+		if repo.video.Status != entity.StatusDeleted || len(uploader.aborted) != 1 {
+			t.Errorf("expected deleted video and 1 aborted upload, got status (%s) and %d aborted uploads", repo.video.Status, len(uploader.aborted))
+		}
+	}
+}
+
 func TestCreateVideo(t *testing.T) {
 	tests := []struct {
 		body        string
@@ -51,6 +219,10 @@ func TestCreateVideo(t *testing.T) {
 		{"{}", map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}}, "/molpastream/v1/videos?uploadType=", errors.New("Invalid upload type")},
 		{"{}", map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}}, "/molpastream/v1/videos?uploadType=media", nil},
 		{"{}", map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}}, "/molpastream/v1/videos?uploadType=resumable", nil},
+		{"{}", map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}, "X-Upload-Checksum-Sha256": {"foo"}}, "/molpastream/v1/videos?uploadType=resumable", errors.New("invalid X-Upload-Checksum-SHA256 header")},
+		{"{}", map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}, "X-Upload-Checksum-Sha256": {sha256Base64(nil)}}, "/molpastream/v1/videos?uploadType=resumable", nil},
+		{`{"profile": "premium-4k"}`, map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}}, "/molpastream/v1/videos?uploadType=media", nil},
+		{`{"profile": "foo"}`, map[string][]string{"X-Upload-Content-Type": {"video/mp4"}, "X-Upload-Content-Length": {"41943040"}}, "/molpastream/v1/videos?uploadType=media", errors.New(`unknown transcode profile "foo"`)},
 	}
 	for _, tt := range tests {
 		r, err := http.NewRequest("POST", tt.path, bytes.NewBuffer([]byte(tt.body)))
@@ -59,11 +231,68 @@ func TestCreateVideo(t *testing.T) {
 		}
 		r.Header = tt.headers
 		w := httptest.NewRecorder()
-		c := &controller{&mockVideoRepoistory{}, &mockUploader{}}
+		repo := &mockVideoRepoistory{}
+		c := &controller{repo, &mockUploader{}, &mockDownloader{}}
 		err = c.createVideo(w, r)
 		if !errors.Is(err, tt.expectedErr) {
 			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
 		}
+		if err != nil {
+			continue
+		}
+		// The default profile is stored if the video is created without a profile.
+		var data VideoRequest
+		json.Unmarshal([]byte(tt.body), &data)
+		if data.Profile == "" {
+			data.Profile = entity.DefaultProfile
+		}
+		if repo.video.Profile != data.Profile {
+			t.Errorf("expected profile (%s), got profile (%s)", data.Profile, repo.video.Profile)
+		}
+	}
+}
+
+func TestCreateMultipartVideo(t *testing.T) {
+	const boundary = "foo_bar_baz"
+	body := func(metadataType, mediaType, media string) string {
+		return fmt.Sprintf("--%[1]s\r\nContent-Type: %[2]s\r\n\r\n{\"title\": \"foo\"}\r\n--%[1]s\r\nContent-Type: %[3]s\r\n\r\n%[4]s\r\n--%[1]s--\r\n", boundary, metadataType, mediaType, media)
+	}
+	tests := []struct {
+		body        string
+		headers     http.Header
+		expectedErr error
+	}{
+		{body("application/json", "video/mp4", "hello"), map[string][]string{"Content-Type": {"application/json"}}, errors.New("Content-Type must be multipart/related")},
+		{body("text/plain", "video/mp4", "hello"), map[string][]string{}, errors.New("Content-Type of metadata part must be application/json")},
+		{body("application/json; charset=UTF-8", "video/mp4", ""), map[string][]string{}, errors.New("size must be greater than 0 bytes")},
+		{body("application/json", "video/mp4", "hello"), map[string][]string{"X-Upload-Checksum-Sha256": {sha256Base64([]byte("world"))}}, errors.New("checksum of the uploaded file mismatched")},
+		{body("application/json", "video/mp4", "hello"), map[string][]string{"X-Upload-Checksum-Sha256": {sha256Base64([]byte("hello"))}}, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("POST", "/molpastream/v1/videos?uploadType=multipart", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = tt.headers
+		if r.Header.Get("Content-Type") == "" {
+			r.Header.Set("Content-Type", "multipart/related; boundary="+boundary)
+		}
+		w := httptest.NewRecorder()
+		repo := &mockVideoRepoistory{}
+		c := &controller{repo, &mockUploader{}, &mockDownloader{}}
+		err = c.createVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if repo.video.Title != "foo" || repo.video.ContentType != "video/mp4" || repo.video.Size != 5 {
+			t.Errorf("expected video (foo, video/mp4, 5), got video (%s, %s, %d)", repo.video.Title, repo.video.ContentType, repo.video.Size)
+		}
+		if repo.video.Status != entity.StatusUploaded {
+			t.Errorf("expected status (%s), got status (%s)", entity.StatusUploaded, repo.video.Status)
+		}
 	}
 }
 
@@ -75,16 +304,27 @@ func TestUploadVideo(t *testing.T) {
 		expectedErr error
 	}{
 		{map[string][]string{}, "", nil, errors.New(`cannot parse Content-Length header: strconv.ParseInt: parsing "": invalid syntax`)},
-		{map[string][]string{"Content-Length": {"-1"}}, "", nil, fmt.Errorf("size must between %d and %d bytes", minUploadChunkSize, maxUploadChunkSize)},
-		{map[string][]string{"Content-Length": {"10485761"}}, "", nil, fmt.Errorf("size must between %d and %d bytes", minUploadChunkSize, maxUploadChunkSize)},
-		{map[string][]string{"Content-Length": {"262145"}}, "", nil, fmt.Errorf("size must be the multiple of %d bytes", minUploadChunkSize)},
+		{map[string][]string{"Content-Length": {"-1"}}, "", nil, errors.New("size must be greater than 0 bytes")},
+		{map[string][]string{"Content-Length": {"10485761"}}, "uploadType=resumable", nil, fmt.Errorf("size must between %d and %d bytes", minUploadChunkSize, maxUploadChunkSize)},
+		{map[string][]string{"Content-Length": {"262145"}}, "uploadType=resumable", nil, fmt.Errorf("size must be the multiple of %d bytes", minUploadChunkSize)},
+		{map[string][]string{"Content-Length": {"10485761"}}, "uploadType=media", &entity.Video{Size: 10485761, Status: entity.StatusCreated}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Md5": {"foo"}}, "uploadType=media", &entity.Video{Size: 1048576, Status: entity.StatusCreated}, errors.New("invalid Content-MD5 header")},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Md5": {md5Base64([]byte("foo"))}}, "uploadType=media", &entity.Video{Size: 1048576, Status: entity.StatusCreated}, repository.ErrChecksumMismatch},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Md5": {md5Base64(nil)}}, "uploadType=media", &entity.Video{Size: 1048576, Status: entity.StatusCreated}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{Size: 1048576, Status: entity.StatusCreated, ExpectedSHA256: sha256Base64([]byte("foo"))}, errors.New("checksum of the uploaded file mismatched")},
 		{map[string][]string{"Content-Length": {"1048576"}}, "", nil, errors.New("video ID does not exist")},
 		{map[string][]string{"Content-Length": {"1048576"}}, "", &entity.Video{}, errors.New("Invalid upload type")},
-		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{Size: 1048576, Status: entity.StatusCreated}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{Size: 1048577, Status: entity.StatusCreated}, errors.New("Content-Length must be the size of the video (1048577 bytes)")},
+		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{Size: 1048575, Status: entity.StatusCreated}, errors.New("Content-Length must be the size of the video (1048575 bytes)")},
+		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=media", &entity.Video{Status: entity.StatusUploaded}, errors.New("video cannot be uploaded in UPLOADED status")},
 		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=resumable", &entity.Video{}, errors.New("Content-Range must be required")},
 		{map[string][]string{"Content-Length": {"1048576"}}, "uploadType=resumable", &entity.Video{}, errors.New("Content-Range must be required")},
-		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 0-1048575/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Upload: &entity.UploadProgress{Id: "1"}}, nil},
-		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 9437184-10485759/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Upload: &entity.UploadProgress{Id: "1"}}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 0-1048575/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 9437184-10485759/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, nil},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 0-1048575/524288"}}, "uploadType=resumable", &entity.Video{Size: 524288, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, errors.New("invalid range of Content-Range header")},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 1048576-0/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, errors.New("invalid range of Content-Range header")},
+		{map[string][]string{"Content-Length": {"1048576"}, "Content-Range": {"bytes 0-1048575/10485760"}}, "uploadType=resumable", &entity.Video{Size: 10485760, Status: entity.StatusFailed, Upload: &entity.UploadProgress{Id: "1"}}, errors.New("video cannot be uploaded in FAILED status")},
 	}
 	for _, tt := range tests {
 		r, err := http.NewRequest("PUT", fmt.Sprintf("/upload/molpastream/v1/videos/1?%s", tt.query), bytes.NewBuffer(nil))
@@ -94,7 +334,7 @@ func TestUploadVideo(t *testing.T) {
 		r.Header = tt.headers
 		r = mux.SetURLVars(r, map[string]string{"id": "1"})
 		w := httptest.NewRecorder()
-		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}}
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{}}
 		err = c.uploadVideo(w, r)
 		if !errors.Is(err, tt.expectedErr) {
 			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
@@ -102,6 +342,367 @@ func TestUploadVideo(t *testing.T) {
 	}
 }
 
+func TestUploadVideoParts(t *testing.T) {
+	const size = 12582912
+	tests := []struct {
+		ranges        []string
+		expectedCodes []int
+		expectedParts []int64
+		expectedErr   error
+	}{
+		{[]string{"bytes 0-5242879/12582912", "bytes 5242880-10485759/12582912", "bytes 10485760-12582911/12582912"}, []int{206, 206, 200}, []int64{1, 2, 3}, nil},
+		{[]string{"bytes 10485760-12582911/12582912", "bytes 0-5242879/12582912", "bytes 5242880-10485759/12582912"}, []int{206, 206, 200}, []int64{1, 2, 3}, nil},
+		{[]string{"bytes 0-5242879/12582912", "bytes 0-5242879/12582912", "bytes 10485760-12582911/12582912", "bytes 5242880-10485759/12582912"}, []int{206, 206, 206, 200}, []int64{1, 2, 3}, nil},
+		{[]string{"bytes 0-10485759/12582912", "bytes 5242880-10485759/12582912"}, []int{206}, nil, errors.New("Content-Range overlaps the uploaded parts")},
+		{[]string{"bytes 1000-1048575/12582912"}, nil, nil, fmt.Errorf("size must be the multiple of %d bytes", minUploadChunkSize)},
+	}
+	for _, tt := range tests {
+		// The checksum is verified by the running digest, or by reading back the parts uploaded out of order.
+		content := strings.Repeat("\x00", size)
+		video := &entity.Video{Id: "1", Size: size, Status: entity.StatusUploading, ExpectedSHA256: sha256Base64([]byte(content))}
+		video.NewUpload("1", time.Now().Add(time.Hour))
+		video.Upload.ChunkSize = uploadChunkSize(size)
+		repo, uploader := &mockVideoRepoistory{video}, &mockUploader{}
+		c := &controller{repo, uploader, &mockDownloader{content}}
+		var err error
+		for i, ra := range tt.ranges {
+			cr, _ := httprange.ParseContentRange(ra)
+			r, _ := http.NewRequest("PUT", "/upload/molpastream/v1/videos/1?uploadType=resumable", bytes.NewBuffer(make([]byte, cr.Length())))
+			r.Header = map[string][]string{"Content-Length": {fmt.Sprint(cr.Length())}, "Content-Range": {ra}}
+			r = mux.SetURLVars(r, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+			if err = c.uploadVideo(w, r); err != nil {
+				break
+			}
+			if w.Code != tt.expectedCodes[i] {
+				t.Errorf("expected status code (%d) of %q, got status code (%d)", tt.expectedCodes[i], ra, w.Code)
+			}
+		}
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if tt.expectedParts == nil {
+			continue
+		}
+		if len(uploader.completed) != 1 {
+			t.Fatalf("expected the upload to be completed once, got %d times", len(uploader.completed))
+		}
+		for i, part := range uploader.completed[0] {
+			if part.PartNumber != tt.expectedParts[i] {
+				t.Errorf("expected part number (%d) at %d, got (%d)", tt.expectedParts[i], i, part.PartNumber)
+			}
+		}
+		if repo.video.Status != entity.StatusUploaded {
+			t.Errorf("expected status (%s), got status (%s)", entity.StatusUploaded, repo.video.Status)
+		}
+		if repo.video.SHA256 != repo.video.ExpectedSHA256 {
+			t.Errorf("expected checksum (%s), got checksum (%s)", repo.video.ExpectedSHA256, repo.video.SHA256)
+		}
+	}
+}
+
+func TestUploadChunkSize(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected int64
+	}{
+		{1, repository.MinPartSize},
+		{maxUploadParts * repository.MinPartSize, repository.MinPartSize},
+		{maxUploadParts*repository.MinPartSize + 1, repository.MinPartSize + minUploadChunkSize},
+		{maxUploadSize, maxUploadChunkSize},
+	}
+	for _, tt := range tests {
+		if n := uploadChunkSize(tt.size); n != tt.expected {
+			t.Errorf("expected chunk size (%d) of %d bytes, got (%d)", tt.expected, tt.size, n)
+		}
+	}
+}
+
+func TestUploadVideoLargeFile(t *testing.T) {
+	// The file of 60 GiB is chunked by 6.25 MiB, so that it is uploaded within the parts limit.
+	const size = 60 << 30
+	chunkSize := uploadChunkSize(size)
+	tests := []struct {
+		ra                 string
+		expectedPartNumber int64
+		expectedErr        error
+	}{
+		{fmt.Sprintf("bytes 0-%d/%d", chunkSize-1, size), 1, nil},
+		{fmt.Sprintf("bytes %d-%d/%d", (size-1)/chunkSize*chunkSize, size-1, size), (size-1)/chunkSize + 1, nil},
+		{fmt.Sprintf("bytes %d-%d/%d", size-1024, size-1, size), 0, fmt.Errorf("start of Content-Range must be the multiple of %d bytes", chunkSize)},
+		{fmt.Sprintf("bytes %d-%d/%d", minUploadChunkSize, 2*minUploadChunkSize-1, size), 0, fmt.Errorf("start of Content-Range must be the multiple of %d bytes", chunkSize)},
+		{fmt.Sprintf("bytes 0-%d/%d", minUploadChunkSize-1, size), 0, fmt.Errorf("size must be the multiple of %d bytes", chunkSize)},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Size: size, Status: entity.StatusUploading}
+		video.NewUpload("1", time.Now().Add(time.Hour))
+		video.Upload.ChunkSize = chunkSize
+		c := &controller{&mockVideoRepoistory{video}, &mockUploader{}, &mockDownloader{}}
+		cr, _ := httprange.ParseContentRange(tt.ra)
+		r, _ := http.NewRequest("PUT", "/upload/molpastream/v1/videos/1?uploadType=resumable", bytes.NewBuffer(make([]byte, cr.Length())))
+		r.Header = map[string][]string{"Content-Length": {fmt.Sprint(cr.Length())}, "Content-Range": {tt.ra}}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		err := c.uploadVideo(httptest.NewRecorder(), r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %q, got error (%v)", tt.expectedErr, tt.ra, err)
+		}
+		if err != nil {
+			continue
+		}
+		if part := video.Upload.Parts[fmt.Sprint(cr.Start)]; part == nil || part.PartNumber != tt.expectedPartNumber {
+			t.Errorf("expected part number (%d) of %q, got part %+v", tt.expectedPartNumber, tt.ra, part)
+		}
+	}
+}
+
+func TestUploadVideoParallelFinalChunks(t *testing.T) {
+	const chunkSize = repository.MinPartSize
+	const size = 2 * chunkSize
+	videos, storage := persistence.NewMemoryVideoRepository(), persistence.NewMemoryStorage()
+	uploadId, err := storage.CreateMultipart("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := entity.NewVideo("1", "", "", "video/mp4", size, nil, nil)
+	video.NewUpload(uploadId, time.Now().Add(time.Hour))
+	video.Status = entity.StatusUploading
+	video.Upload.ChunkSize = chunkSize
+	for offset := int64(0); offset < size; offset += chunkSize {
+		part, err := storage.UploadPart("1", uploadId, bytes.NewReader(make([]byte, chunkSize)), chunkSize, offset/chunkSize+1, entity.Checksum{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Offset, part.Size = offset, chunkSize
+		video.AddUploadPart(part)
+	}
+	if err = videos.Save(video); err != nil {
+		t.Fatal(err)
+	}
+	// The requests of the final chunks both read the video once all bytes were received, before either completes the upload.
+	var requests []*entity.Video
+	for i := 0; i < 2; i++ {
+		stale, err := videos.GetById("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, stale)
+	}
+	c := &controller{videos, storage, storage}
+	for i, stale := range requests {
+		completed, err := c.completeMultipart(stale)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if completed.Status != entity.StatusUploaded {
+			t.Errorf("request %d: expected status (%s), got status (%s)", i, entity.StatusUploaded, completed.Status)
+		}
+	}
+	if n, err := storage.Size("1"); err != nil || n != size {
+		t.Errorf("expected object of %d bytes, got %d bytes with error (%v)", size, n, err)
+	}
+}
+
+func TestUploadVideoCompleteError(t *testing.T) {
+	const size = 1048576
+	const ra = "bytes 0-1048575/1048576"
+	tests := []struct {
+		err            error
+		expectedCode   int
+		expectedStatus string
+	}{
+		{errors.New("connection reset by peer"), http.StatusServiceUnavailable, entity.StatusUploading},
+		{repository.ErrPartTooSmall, http.StatusInternalServerError, entity.StatusFailed},
+		{fmt.Errorf("%w: upload does not exist", repository.ErrInvalidParts), http.StatusInternalServerError, entity.StatusFailed},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Size: size, Status: entity.StatusUploading}
+		video.NewUpload("1", time.Now().Add(time.Hour))
+		video.Upload.ChunkSize = uploadChunkSize(size)
+		repo, uploader := &mockVideoRepoistory{video}, &mockUploader{completeErr: tt.err}
+		c := &controller{repo, uploader, &mockDownloader{}}
+		upload := func() error {
+			r, _ := http.NewRequest("PUT", "/upload/molpastream/v1/videos/1?uploadType=resumable", bytes.NewBuffer(make([]byte, size)))
+			r.Header = map[string][]string{"Content-Length": {fmt.Sprint(size)}, "Content-Range": {ra}}
+			r = mux.SetURLVars(r, map[string]string{"id": "1"})
+			return c.uploadVideo(httptest.NewRecorder(), r)
+		}
+		var aerr *appError
+		if err := upload(); !errors.As(err, &aerr) || aerr.Code != tt.expectedCode {
+			t.Errorf("expected status code (%d) of error (%v), got error (%v)", tt.expectedCode, tt.err, err)
+		}
+		if repo.video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s) of error (%v), got status (%s)", tt.expectedStatus, tt.err, repo.video.Status)
+		}
+		if tt.expectedStatus != entity.StatusUploading {
+			continue
+		}
+		// The final chunk is uploaded again once the storage recovers, which completes the upload.
+		uploader.completeErr = nil
+		if err := upload(); err != nil {
+			t.Fatalf("expected retried final chunk to complete the upload, got error (%v)", err)
+		}
+		if repo.video.Status != entity.StatusUploaded || len(uploader.completed) != 1 {
+			t.Errorf("expected upload to be completed once, got status (%s) with %d completions", repo.video.Status, len(uploader.completed))
+		}
+	}
+}
+
+func TestCancelUpload(t *testing.T) {
+	tests := []struct {
+		video           *entity.Video
+		expectedAborted int
+		expectedErr     error
+	}{
+		{nil, 0, errors.New("video ID does not exist")},
+		{&entity.Video{Status: entity.StatusUploaded}, 0, errors.New("video is not uploaded by resumable upload")},
+		{&entity.Video{Status: entity.StatusUploaded, Upload: &entity.UploadProgress{Id: "1"}}, 0, errors.New("upload has been completed")},
+		{&entity.Video{Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, 1, nil},
+		{&entity.Video{Status: entity.StatusDeleted, Upload: &entity.UploadProgress{Id: "1"}}, 0, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("DELETE", "/upload/molpastream/v1/videos/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		repo, uploader := &mockVideoRepoistory{tt.video}, &mockUploader{}
+		c := &controller{repo, uploader, &mockDownloader{}}
+		err = c.cancelUpload(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if len(uploader.aborted) != tt.expectedAborted {
+			t.Errorf("expected %d aborted uploads, got %d", tt.expectedAborted, len(uploader.aborted))
+		}
+		if w.Code != http.StatusNoContent || repo.video.Status != entity.StatusDeleted {
+			t.Errorf("expected status code (%d) and status (%s), got (%d) and (%s)", http.StatusNoContent, entity.StatusDeleted, w.Code, repo.video.Status)
+		}
+	}
+}
+
+func TestUploadVideoConflict(t *testing.T) {
+	tests := []struct {
+		conflicts   int
+		expectedErr error
+	}{
+		{0, nil},
+		{maxSaveAttempts - 1, nil},
+		{maxSaveAttempts, &repository.ConflictError{Id: "1", Version: maxSaveAttempts - 1}},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Size: 1048576, Status: entity.StatusCreated}
+		repo := &mockConflictVideoRepository{mockVideoRepoistory{video}, tt.conflicts}
+		c := &controller{repo, &mockUploader{}, &mockDownloader{}}
+		r, _ := http.NewRequest("PUT", "/upload/molpastream/v1/videos/1?uploadType=media", bytes.NewBuffer(nil))
+		r.Header = map[string][]string{"Content-Length": {"1048576"}}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		err := c.uploadVideo(httptest.NewRecorder(), r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %d conflicts, got error (%v)", tt.expectedErr, tt.conflicts, err)
+		}
+		if err != nil {
+			continue
+		}
+		// The concurrent change is kept when the upload is completed again on the latest video.
+		if repo.video.Status != entity.StatusUploaded || (tt.conflicts > 0) != (repo.video.Title == "concurrent") {
+			t.Errorf("expected uploaded video keeping the concurrent change, got status (%s) and title (%s)", repo.video.Status, repo.video.Title)
+		}
+	}
+}
+
+func TestGetUploadStatus(t *testing.T) {
+	tests := []struct {
+		headers       http.Header
+		video         *entity.Video
+		expectedCode  int
+		expectedRange string
+		expectedErr   error
+	}{
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, nil, 0, "", errors.New("video ID does not exist")},
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, &entity.Video{Size: 10485760}, 0, "", errors.New("video is not uploaded by resumable upload")},
+		{map[string][]string{"Content-Range": {"bytes */1048576"}}, &entity.Video{Size: 10485760, Upload: &entity.UploadProgress{Id: "1"}}, 0, "", errors.New("invalid size of Content-Range header")},
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, http.StatusPermanentRedirect, "", nil},
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", Parts: map[string]*entity.Part{"0": {Offset: 0, Size: 1048576}, "1048576": {Offset: 1048576, Size: 1048576}, "4194304": {Offset: 4194304, Size: 1048576}}}}, http.StatusPermanentRedirect, "bytes=0-2097151", nil},
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, &entity.Video{Size: 10485760, Status: entity.StatusUploaded, Upload: &entity.UploadProgress{Id: "1"}}, http.StatusOK, "", nil},
+		{map[string][]string{"Content-Range": {"bytes */10485760"}}, &entity.Video{Size: 10485760, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: time.Now().Add(-time.Second)}}, 0, "", errors.New("upload session has expired")},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("PUT", "/upload/molpastream/v1/videos/1?uploadType=resumable", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = tt.headers
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{}}
+		err = c.uploadVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected status code (%d), got status code (%d)", tt.expectedCode, w.Code)
+		}
+		if got := w.Header().Get("Range"); got != tt.expectedRange {
+			t.Errorf("expected Range header (%q), got (%q)", tt.expectedRange, got)
+		}
+	}
+}
+
+func TestStreamVideo(t *testing.T) {
+	completed := &entity.Video{Id: "1", ContentType: "video/mp4", Size: 10, Status: entity.StatusUploaded}
+	tests := []struct {
+		headers      http.Header
+		video        *entity.Video
+		expectedCode int
+		expectedBody string
+		expectedErr  error
+	}{
+		{map[string][]string{}, nil, http.StatusOK, "", errors.New("video ID does not exist")},
+		{map[string][]string{}, &entity.Video{Status: entity.StatusUploading}, http.StatusOK, "", errors.New("video content is not available")},
+		{map[string][]string{}, completed, http.StatusOK, "0123456789", nil},
+		{map[string][]string{"Range": {"bytes=2-5"}}, completed, http.StatusPartialContent, "2345", nil},
+		{map[string][]string{"Range": {"bytes=-3"}}, completed, http.StatusPartialContent, "789", nil},
+		{map[string][]string{"Range": {"bytes=0-1,5-7"}}, completed, http.StatusPartialContent, "", nil},
+		{map[string][]string{"Range": {"bytes=0-9,0-9"}}, completed, http.StatusOK, "0123456789", nil},
+		{map[string][]string{"Range": {"bytes=10-"}}, completed, http.StatusOK, "", errors.New(`range not satisfiable: "bytes=10-"`)},
+		{map[string][]string{"Range": {"bytes=-0"}}, completed, http.StatusOK, "", errors.New(`range not satisfiable: "bytes=-0"`)},
+		// The malformed Range header and other units are ignored.
+		{map[string][]string{"Range": {"bytes=5-2"}}, completed, http.StatusOK, "0123456789", nil},
+		{map[string][]string{"Range": {"bytes=a-b"}}, completed, http.StatusOK, "0123456789", nil},
+		{map[string][]string{"Range": {"items=0-5"}}, completed, http.StatusOK, "0123456789", nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/molpastream/v1/videos/1/media", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = tt.headers
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{"0123456789"}}
+		err = c.streamVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected status code (%d), got status code (%d)", tt.expectedCode, w.Code)
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("expected body (%q), got body (%q)", tt.expectedBody, w.Body.String())
+		}
+	}
+}
+
 type mockVideoRepoistory struct {
 	video *entity.Video
 }
@@ -110,12 +711,96 @@ func (r *mockVideoRepoistory) GetById(id string) (*entity.Video, error) {
 	return r.video, nil
 }
 
+func (r *mockVideoRepoistory) List(filter repository.VideoFilter, cursor string, limit int) ([]*entity.Video, string, error) {
+	if cursor != "" && cursor != "1" {
+		return nil, "", repository.ErrInvalidCursor
+	}
+	if r.video == nil {
+		return nil, "", nil
+	}
+	return []*entity.Video{r.video}, "", nil
+}
+
+func (r *mockVideoRepoistory) Update(video *entity.Video) error {
+	r.video = video
+	return nil
+}
+
+func (r *mockVideoRepoistory) Delete(id string) error {
+	return r.video.Transition(entity.StatusDeleted, time.Now())
+}
+
 func (r *mockVideoRepoistory) Save(video *entity.Video) error {
 	r.video = video
 	return nil
 }
 
+func (r *mockVideoRepoistory) SaveUploadDigest(id, uploadId string, digest *entity.Digest) (*entity.Video, error) {
+	r.video.Upload.Digest = digest
+	return r.video, nil
+}
+
+func (r *mockVideoRepoistory) FindExpiredUploads(now time.Time) ([]*entity.Video, error) {
+	if r.video != nil && r.video.IsUploadExpired(now) {
+		return []*entity.Video{r.video}, nil
+	}
+	return nil, nil
+}
+
+func (r *mockVideoRepoistory) AddUploadPart(id, uploadId string, part *entity.Part) (*entity.Video, error) {
+	r.video.AddUploadPart(part)
+	return r.video, nil
+}
+
+func (r *mockVideoRepoistory) ClaimTranscode(id, source string, now time.Time) (*entity.Video, error) {
+	if !r.video.ClaimTranscode(source, now) {
+		return nil, repository.ErrTranscodeClaimed
+	}
+	return r.video, nil
+}
+
+// The repository modifies the video concurrently before the given number of saves.
+type mockConflictVideoRepository struct {
+	mockVideoRepoistory
+	conflicts int
+}
+
+func (r *mockConflictVideoRepository) GetById(id string) (*entity.Video, error) {
+	video := *r.video
+	return &video, nil
+}
+
+func (r *mockConflictVideoRepository) FindExpiredUploads(now time.Time) ([]*entity.Video, error) {
+	if r.video == nil || !r.video.IsUploadExpired(now) {
+		return nil, nil
+	}
+	video := *r.video
+	return []*entity.Video{&video}, nil
+}
+
+func (r *mockConflictVideoRepository) Delete(id string) error {
+	if r.conflicts == 0 {
+		return r.mockVideoRepoistory.Delete(id)
+	}
+	r.conflicts--
+	return &repository.ConflictError{Id: id, Version: r.video.Version}
+}
+
+func (r *mockConflictVideoRepository) Save(video *entity.Video) error {
+	if r.conflicts == 0 {
+		return r.mockVideoRepoistory.Save(video)
+	}
+	r.conflicts--
+	latest := *r.video
+	latest.Title, latest.Version = "concurrent", latest.Version+1
+	r.video = &latest
+	return &repository.ConflictError{Id: video.Id, Version: video.Version}
+}
+
 type mockUploader struct {
+	completed   [][]*entity.Part
+	aborted     []string
+	completeErr error // The error of completing the multipart uploads.
 }
 
 func (u *mockUploader) CreateMultipart(key string) (string, error) {
@@ -123,13 +808,66 @@ func (u *mockUploader) CreateMultipart(key string) (string, error) {
 }
 
 func (u *mockUploader) CompleteMultipart(key, uploadId string, parts []*entity.Part) error {
+	if u.completeErr != nil {
+		return u.completeErr
+	}
+	for i, part := range parts {
+		if i < len(parts)-1 && part.Size < repository.MinPartSize {
+			return repository.ErrPartTooSmall
+		}
+	}
+	u.completed = append(u.completed, parts)
 	return nil
 }
 
-func (u *mockUploader) SimpleUpload(key string, body []byte) error {
+func (u *mockUploader) AbortMultipart(key, uploadId string) error {
+	u.aborted = append(u.aborted, uploadId)
 	return nil
 }
 
-func (u *mockUploader) UploadPart(key, uploadId string, body []byte, length, partNumber int64) (*entity.Part, error) {
+func (u *mockUploader) SimpleUpload(key string, body io.Reader, length int64, checksum entity.Checksum) error {
+	return verifyChecksum(body, checksum)
+}
+
+func (u *mockUploader) UploadPart(key, uploadId string, body io.Reader, length, partNumber int64, checksum entity.Checksum) (*entity.Part, error) {
+	if err := verifyChecksum(body, checksum); err != nil {
+		return nil, err
+	}
 	return &entity.Part{ETag: "b54357faf0632cce46e942fa68356b38", PartNumber: partNumber}, nil
 }
+
+func verifyChecksum(body io.Reader, checksum entity.Checksum) error {
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if checksum.MD5 != "" && checksum.MD5 != md5Base64(buf) {
+		return repository.ErrChecksumMismatch
+	}
+	if checksum.SHA256 != "" && checksum.SHA256 != sha256Base64(buf) {
+		return repository.ErrChecksumMismatch
+	}
+	return nil
+}
+
+func md5Base64(b []byte) string {
+	sum := md5.Sum(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func sha256Base64(b []byte) string {
+	sum := sha256.Sum256(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type mockDownloader struct {
+	content string
+}
+
+func (d *mockDownloader) Download(key string, start, length int64) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(d.content[start : start+length])), nil
+}
+
+func (d *mockDownloader) Size(key string) (int64, error) {
+	return int64(len(d.content)), nil
+}
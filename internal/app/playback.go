@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/urlsign"
+)
+
+const (
+	defaultPlaybackTTL = time.Hour
+	maxPlaybackTTL     = 24 * time.Hour
+	// The header carries the ID of the user authenticated by the gateway in front of the server.
+	userHeader = "X-User-Id"
+)
+
+type grantKey struct{}
+
+// The controller issues signed URLs to play videos.
+type playbackController struct {
+	video_repo repository.VideoRepository
+	signer     *urlsign.Signer
+}
+
+// Issue the signed URLs of the media and the HLS stream of the video, which expire after the given TTL.
+func (c *playbackController) createPlayback(w http.ResponseWriter, r *http.Request) error {
+	if c.signer == nil {
+		return &appError{http.StatusNotImplemented, "signed URLs are not enabled"}
+	}
+	var req PlaybackRequest
+	if err := parseJSON(w, r, &req); err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if req.TTL == 0 {
+		ttl = defaultPlaybackTTL
+	}
+	if ttl <= 0 || ttl > maxPlaybackTTL {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("ttl must between 1 and %d seconds", int(maxPlaybackTTL.Seconds()))}
+	}
+	if req.ClientIP != "" && net.ParseIP(req.ClientIP) == nil {
+		return &appError{http.StatusBadRequest, "invalid clientIp"}
+	}
+	video, err := c.video_repo.GetById(mux.Vars(r)["id"])
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	if !video.IsAvailable() {
+		return &appError{http.StatusNotFound, "video content is not available"}
+	}
+	// The user is only taken from the gateway, so that URLs cannot be issued on behalf of other users.
+	g := urlsign.Grant{Expires: time.Now().Add(ttl).Truncate(time.Second), IP: req.ClientIP, User: r.Header.Get(userHeader)}
+	resp := PlaybackResponse{MediaURL: c.sign(mediaPath(video.Id), g), ExpiresAt: g.Expires}
+	if video.Status == entity.StatusReady && video.HLS != nil {
+		resp.HLSURL = c.sign(streamFilePath(video.Id, "hls", video.HLS.Playlist), g)
+	}
+	if video.Status == entity.StatusReady && video.DASH != nil {
+		resp.DASHURL = c.sign(streamFilePath(video.Id, "dash", video.DASH.Playlist), g)
+	}
+	if resp.HLSURL != "" || resp.DASHURL != "" {
+		resp.ManifestURL = c.sign(strings.Replace(manifestPathTemplate, "{id}", url.PathEscape(video.Id), 1), g)
+	}
+	return replyJSON(w, resp, http.StatusCreated)
+}
+
+// Get the signed URL of the path.
+func (c *playbackController) sign(path string, g urlsign.Grant) string {
+	return path + "?" + c.signer.Sign(path, g).Encode()
+}
+
+// Get the path of the media of the video.
+func mediaPath(id string) string {
+	return strings.Replace(mediaPathTemplate, "{id}", url.PathEscape(id), 1)
+}
+
+// Verify the signed URL of the request if the signer is given, the grant of the URL is kept in the request context.
+// The clients behind the given trusted proxies are identified by the X-Forwarded-For header.
+func signedURL(signer *urlsign.Signer, proxies []*net.IPNet, h http.Handler) http.Handler {
+	if signer == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g, err := signer.Verify(r.URL.EscapedPath(), r.URL.Query(), time.Now(), clientIP(r, proxies), r.Header.Get(userHeader))
+		if err != nil {
+			code := http.StatusForbidden
+			if errors.Is(err, urlsign.ErrMissingSignature) {
+				code = http.StatusUnauthorized
+			}
+			replyJSON(w, &appError{code, err.Error()}, code)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantKey{}, g)))
+	})
+}
+
+// Get the grant of the signed URL of the request, nil if the request is not verified.
+func requestGrant(r *http.Request) *urlsign.Grant {
+	g, _ := r.Context().Value(grantKey{}).(*urlsign.Grant)
+	return g
+}
+
+// Get the IP address of the client. If the client is connected through the trusted proxies, the addresses appended to
+// the X-Forwarded-For header by the proxies are walked from the right, and the first untrusted address is the client.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip, proxies); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// Determine whether the IP address belongs to the trusted proxies.
+func isTrustedProxy(ip string, proxies []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	for _, p := range proxies {
+		if addr != nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/urlsign"
+)
+
+func TestCreatePlayback(t *testing.T) {
+	signer := urlsign.NewSigner([]byte("secret"))
+	ready := &entity.Video{Id: "1", Status: entity.StatusReady, HLS: &entity.Stream{Playlist: "1.m3u8"}}
+	tests := []struct {
+		body        string
+		user        string
+		video       *entity.Video
+		signer      *urlsign.Signer
+		expectedHLS bool
+		expectedErr error
+	}{
+		{`{}`, "", ready, nil, false, errors.New("signed URLs are not enabled")},
+		{`{"ttl":-1}`, "", ready, signer, false, fmt.Errorf("ttl must between 1 and %d seconds", 86400)},
+		{`{"ttl":86401}`, "", ready, signer, false, fmt.Errorf("ttl must between 1 and %d seconds", 86400)},
+		{`{"clientIp":"foo"}`, "", ready, signer, false, errors.New("invalid clientIp")},
+		{`{}`, "", nil, signer, false, errors.New("video ID does not exist")},
+		{`{}`, "", &entity.Video{Status: entity.StatusUploading}, signer, false, errors.New("video content is not available")},
+		{`{}`, "", &entity.Video{Id: "1", Status: entity.StatusUploaded}, signer, false, nil},
+		{`{"ttl":60,"clientIp":"10.0.0.1"}`, "foo", ready, signer, true, nil},
+		{`{"ttl":60,"userId":"bar"}`, "foo", ready, signer, true, nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("POST", "/molpastream/v1/videos/1/playback", bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.user != "" {
+			r.Header.Set(userHeader, tt.user)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		c := &playbackController{&mockVideoRepoistory{tt.video}, tt.signer}
+		err = c.createPlayback(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %s, got error (%v)", tt.expectedErr, tt.body, err)
+		}
+		if err != nil {
+			continue
+		}
+		var resp PlaybackResponse
+		if err = json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if (resp.HLSURL != "") != tt.expectedHLS {
+			t.Errorf("expected HLS URL (%v) of %s, got (%q)", tt.expectedHLS, tt.body, resp.HLSURL)
+		}
+		// The issued URLs are bound to the user of the gateway and accepted by the verification of signed URLs.
+		for _, rawurl := range []string{resp.MediaURL, resp.HLSURL} {
+			if rawurl == "" {
+				continue
+			}
+			u, _ := url.Parse(rawurl)
+			if user := u.Query().Get(urlsign.UserParam); user != tt.user {
+				t.Errorf("expected URL (%s) bound to user (%q), got user (%q)", rawurl, tt.user, user)
+			}
+			g, err := signer.Verify(u.EscapedPath(), u.Query(), time.Now(), u.Query().Get(urlsign.IPParam), tt.user)
+			if err != nil || !g.Expires.Equal(resp.ExpiresAt) {
+				t.Errorf("expected URL (%s) signed until %v, got error (%v)", rawurl, resp.ExpiresAt, err)
+			}
+		}
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	signer := urlsign.NewSigner([]byte("secret"))
+	const path = "/molpastream/v1/videos/1/media"
+	valid := signer.Sign(path, urlsign.Grant{Expires: time.Now().Add(time.Hour), IP: "10.0.0.1"})
+	_, lb, _ := net.ParseCIDR("192.168.0.0/16")
+	tests := []struct {
+		signer       *urlsign.Signer
+		query        url.Values
+		remoteAddr   string
+		forwardedFor string
+		expectedCode int
+	}{
+		{nil, url.Values{}, "10.0.0.1:1234", "", http.StatusNoContent},
+		{signer, url.Values{}, "10.0.0.1:1234", "", http.StatusUnauthorized},
+		{signer, valid, "10.0.0.1:1234", "", http.StatusNoContent},
+		{signer, valid, "10.0.0.2:1234", "", http.StatusForbidden},
+		{signer, signer.Sign(path, urlsign.Grant{Expires: time.Now().Add(-time.Second)}), "10.0.0.1:1234", "", http.StatusForbidden},
+		{signer, signer.Sign("/molpastream/v1/videos/2/media", urlsign.Grant{Expires: time.Now().Add(time.Hour)}), "10.0.0.1:1234", "", http.StatusForbidden},
+		// The clients behind the trusted proxies are identified by the addresses appended by the proxies.
+		{signer, valid, "192.168.0.1:1234", "10.0.0.1", http.StatusNoContent},
+		{signer, valid, "192.168.0.1:1234", "10.0.0.1, 192.168.0.2", http.StatusNoContent},
+		{signer, valid, "192.168.0.1:1234", "10.0.0.1, 10.0.0.2", http.StatusForbidden},
+		{signer, valid, "192.168.0.1:1234", "", http.StatusForbidden},
+		// The header is spoofed by the clients which are not trusted proxies.
+		{signer, valid, "10.0.0.2:1234", "10.0.0.1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", path+"?"+tt.query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		signedURL(tt.signer, []*net.IPNet{lb}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.signer != nil && requestGrant(r) == nil {
+				t.Errorf("expected grant of verified request, got nil")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected status code (%d) of %s from %s (%s), got status code (%d)", tt.expectedCode, tt.query.Encode(), tt.remoteAddr, tt.forwardedFor, w.Code)
+		}
+	}
+}
+
+func TestServeStreamSigned(t *testing.T) {
+	signer := urlsign.NewSigner([]byte("secret"))
+	video := &entity.Video{Id: "1", Status: entity.StatusReady, HLS: &entity.Stream{Playlist: "1.m3u8"}}
+	streams := mockStreams{"1_360.m3u8": "#EXTM3U\n#EXT-X-MAP:URI=\"1_360_init.mp4\"\n#EXTINF:6,\n1_360_00001.m4s\n"}
+	c := &streamController{&mockVideoRepoistory{video}, streams, signer}
+	g := urlsign.Grant{Expires: time.Now().Add(time.Minute), User: "foo"}
+	path := "/molpastream/v1/videos/1/hls/1_360.m3u8"
+	r, _ := http.NewRequest("GET", path+"?"+signer.Sign(path, g).Encode(), nil)
+	r.Header.Set(userHeader, "foo")
+	r = mux.SetURLVars(r, map[string]string{"id": "1", "format": "hls", "path": "1_360.m3u8"})
+	w := httptest.NewRecorder()
+	signedURL(signer, nil, appHandler(c.serveStream)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code (%d), got status code (%d)", http.StatusOK, w.Code)
+	}
+	// Every URI in the playlist is signed with the grant of the playlist.
+	uris := []string{strings.Split(strings.Split(w.Body.String(), `URI="`)[1], `"`)[0], strings.Split(w.Body.String(), "\n")[3]}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := signer.Verify("/molpastream/v1/videos/1/hls/"+u.Path, u.Query(), time.Now(), "", "foo")
+		if err != nil || !got.Expires.Equal(g.Expires.Truncate(time.Second)) {
+			t.Errorf("expected URI (%s) signed with the grant of the playlist, got error (%v)", uri, err)
+		}
+	}
+}
+
+func TestServeStreamSignedTemplates(t *testing.T) {
+	signer := urlsign.NewSigner([]byte("secret"))
+	video := &entity.Video{Id: "1", Status: entity.StatusReady, DASH: &entity.Stream{Playlist: "1.mpd"}}
+	streams := mockStreams{"1.mpd": `<MPD><SegmentTemplate media="1_360_$Number%09d$.mp4"/></MPD>`}
+	c := &streamController{&mockVideoRepoistory{video}, streams, signer}
+	path := "/molpastream/v1/videos/1/dash/1.mpd"
+	r, _ := http.NewRequest("GET", path+"?"+signer.Sign(path, urlsign.Grant{Expires: time.Now().Add(time.Minute)}).Encode(), nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "1", "format": "dash", "path": "1.mpd"})
+	w := httptest.NewRecorder()
+	signedURL(signer, nil, appHandler(c.serveStream)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code (%d), got status code (%d)", http.StatusOK, w.Code)
+	}
+	// The segments named by the template are signed for the whole stream.
+	media := html.UnescapeString(strings.Split(strings.Split(w.Body.String(), `media="`)[1], `"`)[0])
+	u, err := url.Parse(strings.Replace(media, "$Number%09d$", "000000001", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Verify("/molpastream/v1/videos/1/dash/"+u.Path, u.Query(), time.Now(), "", ""); err != nil {
+		t.Errorf("expected segment (%s) signed for the stream, got error (%v)", media, err)
+	}
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/httprange"
+	"github.com/molpadia/molpastream/internal/urlsign"
+)
+
+const (
+	mediaPathTemplate    = "/molpastream/v1/videos/{id}/media"
+	manifestPathTemplate = "/molpastream/v1/videos/{id}/manifest"
+	streamPathTemplate   = "/molpastream/v1/videos/{id}/{format:hls|dash}/{path}"
+	// Playlists are revalidated shortly, since the stream is replaced if the video is transcoded again.
+	playlistCacheControl = "public, max-age=60"
+	// Segments are never modified once they are produced.
+	segmentCacheControl = "public, max-age=31536000, immutable"
+)
+
+// The format of the streams of transcoded videos.
+type streamFormat struct {
+	// The content types of the files of the stream keyed by the file extension.
+	contentTypes map[string]string
+	// The extension of the master playlist or manifest, and the media playlists.
+	manifestExt string
+	// The segments are named by templates in the manifest, so the URIs are signed for the whole stream.
+	templated bool
+	// Get the stream of the video in the format.
+	stream func(video *entity.Video) *entity.Stream
+	// Rewrite the URIs in the manifest or playlist.
+	rewrite func(manifest []byte, rewrite func(uri string) string) []byte
+}
+
+// The formats of streams keyed by the name of the format.
+var streamFormats = map[string]*streamFormat{
+	"hls": {
+		contentTypes: map[string]string{
+			".m3u8": "application/vnd.apple.mpegurl",
+			".ts":   "video/mp2t",
+			".m4s":  "video/iso.segment",
+			".mp4":  "video/mp4",
+			".aac":  "audio/aac",
+			".vtt":  "text/vtt",
+		},
+		manifestExt: ".m3u8",
+		stream:      func(video *entity.Video) *entity.Stream { return video.HLS },
+		rewrite:     rewritePlaylist,
+	},
+	"dash": {
+		contentTypes: map[string]string{
+			".mpd": "application/dash+xml",
+			".m4s": "video/iso.segment",
+			".mp4": "video/mp4",
+			".vtt": "text/vtt",
+		},
+		manifestExt: ".mpd",
+		templated:   true,
+		stream:      func(video *entity.Video) *entity.Stream { return video.DASH },
+		rewrite:     rewriteManifest,
+	},
+}
+
+var (
+	// The URI attribute of the playlist tags, e.g. #EXT-X-MEDIA and #EXT-X-MAP.
+	uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+	// The attributes of the manifest elements refer to segments, e.g. <SegmentTemplate> and <SegmentURL>.
+	segmentAttribute = regexp.MustCompile(`\b(media|initialization|sourceURL)="([^"]*)"`)
+	// The base URL of the segments in the manifest.
+	baseURLElement = regexp.MustCompile(`<BaseURL>([^<]*)</BaseURL>`)
+)
+
+// The controller serves the streams of transcoded videos from the storage of the streams.
+type streamController struct {
+	video_repo repository.VideoRepository
+	streams    repository.Downloader
+	signer     *urlsign.Signer
+}
+
+// Serve the manifests, playlists and segments of the HLS or DASH stream of the video,
+// supporting byte-range requests of segments.
+func (c *streamController) serveStream(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	format := streamFormats[vars["format"]]
+	if format == nil {
+		return &appError{http.StatusNotFound, "format of the stream is not supported"}
+	}
+	video, stream, err := c.readyStream(vars["id"], format)
+	if err != nil {
+		return err
+	}
+	key, ok := streamKey(stream, format, vars["path"])
+	if !ok {
+		return &appError{http.StatusNotFound, "file of the stream does not exist"}
+	}
+	size, err := c.streams.Size(key)
+	if errors.Is(err, repository.ErrFileNotFound) {
+		return &appError{http.StatusNotFound, "file of the stream does not exist"}
+	}
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	src := func(start, length int64) (io.ReadCloser, error) {
+		return c.streams.Download(key, start, length)
+	}
+	contentType := format.contentTypes[path.Ext(key)]
+	if path.Ext(key) != format.manifestExt {
+		w.Header().Set("Cache-Control", segmentCacheControl)
+		return serveRanges(w, r, size, contentType, src)
+	}
+	// Manifests are rewritten, so byte ranges of the stored manifest do not apply.
+	manifest, err := readAll(src, size)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	// The files are signed with the grant of the manifest, so that they expire along with the manifest.
+	g := requestGrant(r)
+	manifest = format.rewrite(manifest, func(uri string) string {
+		uri = relativeURI(uri)
+		if c.signer == nil || g == nil || strings.Contains(uri, "?") {
+			return uri
+		}
+		grant := *g
+		if format.templated {
+			grant.Scope = streamFilePath(video.Id, vars["format"], "")
+		}
+		return uri + "?" + c.signer.Sign(streamFilePath(video.Id, vars["format"], uri), grant).Encode()
+	})
+	w.Header().Set("Cache-Control", playlistCacheControl)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+	_, err = w.Write(manifest)
+	return err
+}
+
+// Redirect to the HLS master playlist or the DASH manifest of the video, the format is given by the "format" query
+// parameter or negotiated by the Accept header.
+func (c *streamController) redirectManifest(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = acceptedFormat(r.Header.Get("Accept"))
+	}
+	format := streamFormats[name]
+	if format == nil {
+		return &appError{http.StatusBadRequest, "format must be hls or dash"}
+	}
+	video, stream, err := c.readyStream(mux.Vars(r)["id"], format)
+	if err != nil {
+		return err
+	}
+	location := streamFilePath(video.Id, name, stream.Playlist)
+	// The manifest is signed with the grant of the request, so that the signed URL of the manifest keeps working.
+	if g := requestGrant(r); c.signer != nil && g != nil {
+		location += "?" + c.signer.Sign(location, *g).Encode()
+	}
+	w.Header().Set("Vary", "Accept")
+	http.Redirect(w, r, location, http.StatusFound)
+	return nil
+}
+
+// Get the ready video and its stream of the format.
+func (c *streamController) readyStream(id string, format *streamFormat) (*entity.Video, *entity.Stream, error) {
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return nil, nil, &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return nil, nil, &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return nil, nil, &appError{http.StatusGone, "video has been deleted"}
+	}
+	stream := format.stream(video)
+	if video.Status != entity.StatusReady || stream == nil {
+		return nil, nil, &appError{http.StatusNotFound, "video stream is not available"}
+	}
+	return video, stream, nil
+}
+
+// Get the name of the format of the first manifest type in the Accept header, HLS is served by default.
+func acceptedFormat(accept string) string {
+	for _, v := range strings.Split(accept, ",") {
+		t, _, err := mime.ParseMediaType(v)
+		if err != nil {
+			continue
+		}
+		switch t {
+		case "application/dash+xml":
+			return "dash"
+		case "application/vnd.apple.mpegurl", "application/x-mpegurl":
+			return "hls"
+		}
+	}
+	return "hls"
+}
+
+// Get the key of the file of the stream by the path relative to the master playlist or manifest.
+// Only the files named after the manifest are served, e.g. 1.m3u8, 1_360.m3u8 and 1_360_00001.ts.
+func streamKey(stream *entity.Stream, format *streamFormat, p string) (string, bool) {
+	name := path.Base(stream.Playlist)
+	prefix := strings.TrimSuffix(name, path.Ext(name))
+	if strings.Contains(p, "/") || p != name && !strings.HasPrefix(p, prefix+"_") {
+		return "", false
+	}
+	if _, ok := format.contentTypes[path.Ext(p)]; !ok {
+		return "", false
+	}
+	return path.Join(path.Dir(stream.Playlist), p), true
+}
+
+// Get the path of the file of the stream in the format served by the proxy,
+// the path of the directory of the stream is returned if the key is empty.
+func streamFilePath(id, format, key string) string {
+	name := ""
+	if key != "" {
+		name = url.PathEscape(path.Base(key))
+	}
+	return strings.NewReplacer("{id}", url.PathEscape(id), "{format:hls|dash}", format, "{path}", name).Replace(streamPathTemplate)
+}
+
+// Rewrite the URIs of the media playlists, segments and keys in the HLS playlist.
+func rewritePlaylist(playlist []byte, rewrite func(uri string) string) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(playlist), "\n") {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		if strings.HasPrefix(content, "#") {
+			content = uriAttribute.ReplaceAllStringFunc(content, func(attr string) string {
+				return `URI="` + rewrite(uriAttribute.FindStringSubmatch(attr)[1]) + `"`
+			})
+		} else if uri := strings.TrimSpace(content); uri != "" {
+			content = rewrite(uri)
+		}
+		buf.WriteString(content + eol)
+	}
+	return buf.Bytes()
+}
+
+// Rewrite the URIs of the segments in the DASH manifest.
+// The absolute base URLs are removed, so that the segments are relative to the manifest.
+func rewriteManifest(manifest []byte, rewrite func(uri string) string) []byte {
+	manifest = baseURLElement.ReplaceAllFunc(manifest, func(elem []byte) []byte {
+		if uri := html.UnescapeString(string(baseURLElement.FindSubmatch(elem)[1])); relativeURI(uri) != uri {
+			return nil
+		}
+		return elem
+	})
+	return segmentAttribute.ReplaceAllFunc(manifest, func(attr []byte) []byte {
+		m := segmentAttribute.FindSubmatch(attr)
+		return []byte(string(m[1]) + `="` + html.EscapeString(rewrite(html.UnescapeString(string(m[2])))) + `"`)
+	})
+}
+
+// Get the URI relative to the manifest, the absolute URIs of the storage are replaced with the name of the file,
+// so that the files are requested from the proxy rather than the storage of the stream.
+func relativeURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() && !strings.HasPrefix(u.Path, "/") {
+		return uri
+	}
+	return path.Base(u.EscapedPath())
+}
+
+// Read the entire file of the given size.
+func readAll(src httprange.SourceFunc, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	body, err := src(0, size)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+func TestServeStream(t *testing.T) {
+	ready := &entity.Video{Id: "1", Status: entity.StatusReady, HLS: &entity.Stream{Bucket: "bucket", Playlist: "1.m3u8"}, DASH: &entity.Stream{Bucket: "bucket", Playlist: "1.mpd"}}
+	streams := mockStreams{
+		"1.m3u8":         "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1200000\ns3://bucket/1_360.m3u8\n",
+		"1_360.m3u8":     "#EXTM3U\n#EXTINF:6,\n1_360_00001.ts\n",
+		"1_360_00001.ts": "0123456789",
+		"2.m3u8":         "#EXTM3U\n",
+		"1.mpd":          `<MPD><BaseURL>s3://bucket/</BaseURL><SegmentTemplate media="1_360_$Number%09d$.mp4" initialization="s3://bucket/1_360init.mp4"/></MPD>`,
+		"1_360init.mp4":  "init",
+	}
+	tests := []struct {
+		format              string
+		path                string
+		headers             http.Header
+		video               *entity.Video
+		expectedCode        int
+		expectedContentType string
+		expectedBody        string
+		expectedErr         error
+	}{
+		{"hls", "1.m3u8", map[string][]string{}, nil, http.StatusOK, "", "", errors.New("video ID does not exist")},
+		{"hls", "1.m3u8", map[string][]string{}, &entity.Video{Status: entity.StatusUploaded}, http.StatusOK, "", "", errors.New("video stream is not available")},
+		{"hls", "1.m3u8", map[string][]string{}, &entity.Video{Status: entity.StatusDeleted}, http.StatusOK, "", "", errors.New("video has been deleted")},
+		{"hls", "2.m3u8", map[string][]string{}, ready, http.StatusOK, "", "", errors.New("file of the stream does not exist")},
+		{"hls", "1_360.mp3", map[string][]string{}, ready, http.StatusOK, "", "", errors.New("file of the stream does not exist")},
+		{"hls", "1_720.m3u8", map[string][]string{}, ready, http.StatusOK, "", "", errors.New("file of the stream does not exist")},
+		{"hls", "1.m3u8", map[string][]string{"Range": {"bytes=0-1"}}, ready, http.StatusOK, "application/vnd.apple.mpegurl", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1200000\n1_360.m3u8\n", nil},
+		{"hls", "1_360.m3u8", map[string][]string{}, ready, http.StatusOK, "application/vnd.apple.mpegurl", "#EXTM3U\n#EXTINF:6,\n1_360_00001.ts\n", nil},
+		{"hls", "1_360_00001.ts", map[string][]string{}, ready, http.StatusOK, "video/mp2t", "0123456789", nil},
+		{"hls", "1_360_00001.ts", map[string][]string{"Range": {"bytes=2-5"}}, ready, http.StatusPartialContent, "video/mp2t", "2345", nil},
+		{"dash", "1.m3u8", map[string][]string{}, ready, http.StatusOK, "", "", errors.New("file of the stream does not exist")},
+		{"dash", "1.mpd", map[string][]string{}, &entity.Video{Status: entity.StatusReady, HLS: ready.HLS}, http.StatusOK, "", "", errors.New("video stream is not available")},
+		{"dash", "1.mpd", map[string][]string{}, ready, http.StatusOK, "application/dash+xml", `<MPD><SegmentTemplate media="1_360_$Number%09d$.mp4" initialization="1_360init.mp4"/></MPD>`, nil},
+		{"dash", "1_360init.mp4", map[string][]string{}, ready, http.StatusOK, "video/mp4", "init", nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/molpastream/v1/videos/1/"+tt.format+"/"+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = tt.headers
+		r = mux.SetURLVars(r, map[string]string{"id": "1", "format": tt.format, "path": tt.path})
+		w := httptest.NewRecorder()
+		c := &streamController{&mockVideoRepoistory{tt.video}, streams, nil}
+		err = c.serveStream(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %s, got error (%v)", tt.expectedErr, tt.path, err)
+		}
+		if err != nil {
+			continue
+		}
+		if w.Code != tt.expectedCode || w.Header().Get("Content-Type") != tt.expectedContentType {
+			t.Errorf("expected status code (%d) and content type (%s) of %s, got (%d) and (%s)", tt.expectedCode, tt.expectedContentType, tt.path, w.Code, w.Header().Get("Content-Type"))
+		}
+		if w.Body.String() != tt.expectedBody {
+			t.Errorf("expected body (%q) of %s, got body (%q)", tt.expectedBody, tt.path, w.Body.String())
+		}
+		if w.Header().Get("Cache-Control") == "" {
+			t.Errorf("expected Cache-Control header of %s, got none", tt.path)
+		}
+	}
+}
+
+func TestRewritePlaylist(t *testing.T) {
+	tests := []struct {
+		playlist string
+		expected string
+	}{
+		{"#EXTM3U\n1_360.m3u8\n", "#EXTM3U\n1_360.m3u8\n"},
+		{"#EXTM3U\r\ns3://bucket/1_360.m3u8\r\n", "#EXTM3U\r\n1_360.m3u8\r\n"},
+		{"#EXTINF:6,\nhttps://bucket.s3.amazonaws.com/1_360_00001.ts", "#EXTINF:6,\n1_360_00001.ts"},
+		{"#EXTINF:6,\n/1/1_360_00001.ts?foo=bar\n", "#EXTINF:6,\n1_360_00001.ts\n"},
+		{`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="s3://bucket/1_audio.m3u8"` + "\n", `#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="1_audio.m3u8"` + "\n"},
+		{`#EXT-X-MAP:URI="1_init.mp4"` + "\n", `#EXT-X-MAP:URI="1_init.mp4"` + "\n"},
+	}
+	for _, tt := range tests {
+		if got := string(rewritePlaylist([]byte(tt.playlist), relativeURI)); got != tt.expected {
+			t.Errorf("rewritePlaylist(%q) = %q, want %q", tt.playlist, got, tt.expected)
+		}
+	}
+}
+
+func TestRewriteManifest(t *testing.T) {
+	tests := []struct {
+		manifest string
+		expected string
+	}{
+		{`<BaseURL>https://bucket.s3.amazonaws.com/</BaseURL><SegmentURL media="1_360_1.mp4"/>`, `<SegmentURL media="1_360_1.mp4?signed"/>`},
+		{`<BaseURL>./</BaseURL><SegmentTemplate media="s3://bucket/1_360_$Number$.mp4"/>`, `<BaseURL>./</BaseURL><SegmentTemplate media="1_360_$Number$.mp4?signed"/>`},
+		{`<Initialization sourceURL="1_360init.mp4"/>`, `<Initialization sourceURL="1_360init.mp4?signed"/>`},
+	}
+	for _, tt := range tests {
+		got := string(rewriteManifest([]byte(tt.manifest), func(uri string) string { return relativeURI(uri) + "?signed" }))
+		if got != tt.expected {
+			t.Errorf("rewriteManifest(%q) = %q, want %q", tt.manifest, got, tt.expected)
+		}
+	}
+}
+
+func TestRedirectManifest(t *testing.T) {
+	ready := &entity.Video{Id: "1", Status: entity.StatusReady, HLS: &entity.Stream{Playlist: "1.m3u8"}, DASH: &entity.Stream{Playlist: "1.mpd"}}
+	tests := []struct {
+		query            string
+		accept           string
+		video            *entity.Video
+		expectedLocation string
+		expectedErr      error
+	}{
+		{"", "", ready, "/molpastream/v1/videos/1/hls/1.m3u8", nil},
+		{"", "application/dash+xml, application/vnd.apple.mpegurl", ready, "/molpastream/v1/videos/1/dash/1.mpd", nil},
+		{"", "application/x-mpegURL;q=0.9, application/dash+xml", ready, "/molpastream/v1/videos/1/hls/1.m3u8", nil},
+		{"format=dash", "application/vnd.apple.mpegurl", ready, "/molpastream/v1/videos/1/dash/1.mpd", nil},
+		{"format=smooth", "", ready, "", errors.New("format must be hls or dash")},
+		{"format=dash", "", &entity.Video{Id: "1", Status: entity.StatusReady, HLS: ready.HLS}, "", errors.New("video stream is not available")},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/molpastream/v1/videos/1/manifest?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept", tt.accept)
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		c := &streamController{&mockVideoRepoistory{tt.video}, mockStreams{}, nil}
+		err = c.redirectManifest(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %q, got error (%v)", tt.expectedErr, tt.query, err)
+		}
+		if err == nil && (w.Code != http.StatusFound || w.Header().Get("Location") != tt.expectedLocation) {
+			t.Errorf("expected redirect to (%s) of %q %q, got (%d) to (%s)", tt.expectedLocation, tt.query, tt.accept, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+// The storage of streams keeps the files keyed by the keys.
+type mockStreams map[string]string
+
+func (s mockStreams) Download(key string, start, length int64) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(s[key][start : start+length])), nil
+}
+
+func (s mockStreams) Size(key string) (int64, error) {
+	content, ok := s[key]
+	if !ok {
+		return 0, repository.ErrFileNotFound
+	}
+	return int64(len(content)), nil
+}
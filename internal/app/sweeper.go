@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The error is returned if the upload of the video has not expired when the video is marked as failed.
+var errUploadNotExpired = errors.New("upload has not expired")
+
+// The sweeper aborts the expired resumable uploads and marks their videos as failed.
+type sweeper struct {
+	video_repo repository.VideoRepository
+	uploader   repository.Uploader
+}
+
+// Run the sweeper on the given interval in background until the context is done.
+func StartUploadSweeper(ctx context.Context, videos repository.VideoRepository, uploader repository.Uploader, interval time.Duration) {
+	s := &sweeper{videos, uploader}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := s.sweep(now); err != nil {
+					log.Printf("failed to sweep expired uploads: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Mark the videos whose upload has expired at the given time as failed, and abort their uploads.
+// The videos are marked before the uploads are aborted, so that a chunk uploaded concurrently never leaves the video
+// uploading with the aborted upload. The videos failed to be swept are swept again next time.
+func (s *sweeper) sweep(now time.Time) error {
+	videos, err := s.video_repo.FindExpiredUploads(now)
+	if err != nil {
+		return err
+	}
+	for _, video := range videos {
+		err = saveVideo(s.video_repo, video, func(video *entity.Video) error {
+			// The upload may have been completed concurrently.
+			if !video.IsUploadExpired(now) {
+				return errUploadNotExpired
+			}
+			return video.Transition(entity.StatusFailed, now)
+		})
+		if errors.Is(err, errUploadNotExpired) {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to mark expired upload %s of video %s as failed: %v", video.Upload.Id, video.Id, err)
+			continue
+		}
+		// The multipart upload may have been removed by the storage, the video is failed anyway.
+		if err = s.uploader.AbortMultipart(video.Id, video.Upload.Id); err != nil {
+			log.Printf("failed to abort upload %s of video %s: %v", video.Upload.Id, video.Id, err)
+		}
+		log.Printf("expired upload %s of video %s has been swept", video.Upload.Id, video.Id)
+	}
+	return nil
+}
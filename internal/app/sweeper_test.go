@@ -0,0 +1,63 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+func TestSweep(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		video           *entity.Video
+		expectedStatus  string
+		expectedAborted int
+	}{
+		{&entity.Video{Id: "1", Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: now.Add(-time.Second)}}, entity.StatusFailed, 1},
+		{&entity.Video{Id: "1", Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: now.Add(time.Second)}}, entity.StatusUploading, 0},
+		{&entity.Video{Id: "1", Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1"}}, entity.StatusUploading, 0},
+		{&entity.Video{Id: "1", Status: entity.StatusUploaded, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: now.Add(-time.Second)}}, entity.StatusUploaded, 0},
+	}
+	for _, tt := range tests {
+		repo, uploader := &mockVideoRepoistory{tt.video}, &mockUploader{}
+		s := &sweeper{repo, uploader}
+		if err := s.sweep(now); err != nil {
+			t.Fatal(err)
+		}
+		if repo.video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s), got status (%s)", tt.expectedStatus, repo.video.Status)
+		}
+		if len(uploader.aborted) != tt.expectedAborted {
+			t.Errorf("expected %d aborted uploads, got %d", tt.expectedAborted, len(uploader.aborted))
+		}
+	}
+}
+
+func TestSweepConflict(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		conflicts       int
+		expectedStatus  string
+		expectedAborted int
+	}{
+		// The video modified concurrently is marked as failed again.
+		{1, entity.StatusFailed, 1},
+		// The video is left uploading and its upload is kept if the video cannot be marked.
+		{maxSaveAttempts, entity.StatusUploading, 0},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: now.Add(-time.Second)}}
+		repo, uploader := &mockConflictVideoRepository{mockVideoRepoistory{video}, tt.conflicts}, &mockUploader{}
+		s := &sweeper{repo, uploader}
+		if err := s.sweep(now); err != nil {
+			t.Fatal(err)
+		}
+		if repo.video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s), got status (%s)", tt.expectedStatus, repo.video.Status)
+		}
+		if len(uploader.aborted) != tt.expectedAborted {
+			t.Errorf("expected %d aborted uploads, got %d", tt.expectedAborted, len(uploader.aborted))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The number of uploaded videos listed at a time by the dispatcher.
+const dispatchPageSize = 100
+
+// The dispatcher launches the transcoding jobs of the uploaded videos, which stands in for the S3 events
+// triggering the batch_transcode lambda if videos are transcoded by a local transcoder.
+type transcodeDispatcher struct {
+	video_repo repository.VideoRepository
+	transcoder repository.Transcoder
+}
+
+// Run the dispatcher on the given interval in background until the context is done.
+func StartTranscodeDispatcher(ctx context.Context, videos repository.VideoRepository, transcoder repository.Transcoder, interval time.Duration) {
+	d := &transcodeDispatcher{videos, transcoder}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := d.dispatch(now); err != nil {
+					log.Printf("failed to dispatch transcoding jobs: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Launch the transcoding jobs of the uploaded videos and mark the videos as transcoding at the given time.
+// The videos whose job cannot be launched are left uploaded, so they are dispatched again later.
+func (d *transcodeDispatcher) dispatch(now time.Time) error {
+	var cursor string
+	for {
+		videos, next, err := d.video_repo.List(repository.VideoFilter{Status: entity.StatusUploaded}, cursor, dispatchPageSize)
+		if err != nil {
+			return err
+		}
+		for _, video := range videos {
+			// The video may have been transcoded since it was listed.
+			if video.Status != entity.StatusUploaded {
+				continue
+			}
+			job, err := d.transcoder.Transcode(video)
+			if err != nil {
+				log.Printf("failed to launch transcoding job of video %s: %v", video.Id, err)
+				continue
+			}
+			if err = video.StartTranscode(job, now); err != nil {
+				log.Printf("failed to mark video %s as transcoding: %v", video.Id, err)
+				continue
+			}
+			// The result of the job is ignored if the video cannot be saved, e.g. it was deleted meanwhile.
+			if err = d.video_repo.Save(video); err != nil {
+				log.Printf("failed to mark video %s as transcoding: %v", video.Id, err)
+				continue
+			}
+			log.Printf("transcoding job %s of video %s has been launched", job.Id, video.Id)
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// The controller transcodes videos again on demand.
+type transcodeController struct {
+	video_repo repository.VideoRepository
+	transcoder repository.Transcoder
+}
+
+// Transcode the uploaded video again, e.g. with another transcode profile or after the transcoding failed.
+// The job is launched regardless of the claim of the source file, and supersedes the running job of the video.
+func (c *transcodeController) retranscodeVideo(w http.ResponseWriter, r *http.Request) error {
+	if c.transcoder == nil {
+		return &appError{http.StatusNotImplemented, "transcoding is not enabled"}
+	}
+	// The request body is optional, the video is transcoded with its own profile by default.
+	var req TranscodeRequest
+	if err := parseJSON(w, r, &req); err != nil && !errors.Is(err, io.EOF) {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("cannot parse JSON from request body: %v", err)}
+	}
+	video, err := c.video_repo.GetById(mux.Vars(r)["id"])
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return &appError{http.StatusGone, "video has been deleted"}
+	}
+	if video.UploadedAt.IsZero() {
+		return &appError{http.StatusConflict, entity.ErrNotUploaded.Error()}
+	}
+	profile := video.Profile
+	if req.Profile != "" {
+		if profile, err = transcodeProfile(req.Profile); err != nil {
+			return err
+		}
+	}
+	source := *video
+	source.Profile = profile
+	job, err := c.transcoder.Transcode(&source)
+	if err != nil {
+		return &appError{http.StatusServiceUnavailable, fmt.Sprintf("cannot launch transcoding job: %v", err)}
+	}
+	now := time.Now()
+	err = saveVideo(c.video_repo, video, func(video *entity.Video) error {
+		video.Profile = profile
+		if err := video.RestartTranscode(job, now); err != nil {
+			return &appError{http.StatusConflict, err.Error()}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("transcoding job %s of video %s has been launched again", job.Id, video.Id)
+	return replyJSON(w, newVideoResponse(video), http.StatusAccepted)
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		video          *entity.Video
+		transcoder     *mockTranscoder
+		expectedStatus string
+		expectedJobs   int
+	}{
+		{&entity.Video{Id: "1", Status: entity.StatusUploaded}, &mockTranscoder{}, entity.StatusTranscoding, 1},
+		{&entity.Video{Id: "1", Status: entity.StatusUploaded}, &mockTranscoder{err: errors.New("queue is full")}, entity.StatusUploaded, 0},
+		{&entity.Video{Id: "1", Status: entity.StatusReady}, &mockTranscoder{}, entity.StatusReady, 0},
+	}
+	for _, tt := range tests {
+		repo := &mockVideoRepoistory{tt.video}
+		d := &transcodeDispatcher{repo, tt.transcoder}
+		if err := d.dispatch(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if repo.video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s), got status (%s)", tt.expectedStatus, repo.video.Status)
+		}
+		if tt.transcoder.jobs != tt.expectedJobs {
+			t.Errorf("expected %d jobs, got %d jobs", tt.expectedJobs, tt.transcoder.jobs)
+		}
+		if tt.expectedJobs > 0 && (repo.video.Transcode == nil || repo.video.Transcode.Id != "job") {
+			t.Errorf("expected video transcoding by job, got job %+v", repo.video.Transcode)
+		}
+	}
+}
+
+func TestRetranscodeVideo(t *testing.T) {
+	uploaded := time.Now()
+	tests := []struct {
+		body            string
+		video           *entity.Video
+		transcoder      *mockTranscoder
+		expectedProfile string
+		expectedErr     error
+	}{
+		{``, &entity.Video{Id: "1", Status: entity.StatusReady, UploadedAt: uploaded}, nil, "", errors.New("transcoding is not enabled")},
+		{`{`, &entity.Video{Id: "1", Status: entity.StatusReady, UploadedAt: uploaded}, &mockTranscoder{}, "", errors.New("cannot parse JSON from request body: unexpected EOF")},
+		{``, nil, &mockTranscoder{}, "", errors.New("video ID does not exist")},
+		{``, &entity.Video{Id: "1", Status: entity.StatusDeleted, UploadedAt: uploaded}, &mockTranscoder{}, "", errors.New("video has been deleted")},
+		{``, &entity.Video{Id: "1", Status: entity.StatusUploading}, &mockTranscoder{}, "", entity.ErrNotUploaded},
+		{`{"profile":"foo"}`, &entity.Video{Id: "1", Status: entity.StatusReady, UploadedAt: uploaded}, &mockTranscoder{}, "", errors.New(`unknown transcode profile "foo"`)},
+		{``, &entity.Video{Id: "1", Status: entity.StatusReady, UploadedAt: uploaded}, &mockTranscoder{err: errors.New("queue is full")}, "", errors.New("cannot launch transcoding job: queue is full")},
+		// The claim of the source file is ignored, and the running job is superseded.
+		{``, &entity.Video{Id: "1", Status: entity.StatusFailed, Profile: "mobile", UploadedAt: uploaded, TranscodeClaim: &entity.TranscodeClaim{Source: "abc"}}, &mockTranscoder{}, "mobile", nil},
+		{`{"profile":"premium-4k"}`, &entity.Video{Id: "1", Status: entity.StatusTranscoding, UploadedAt: uploaded, Transcode: &entity.TranscodeJob{Id: "job0"}}, &mockTranscoder{}, "premium-4k", nil},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest("POST", "/molpastream/v1/videos/1/transcode", bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		repo := &mockVideoRepoistory{tt.video}
+		c := &transcodeController{repo, nil}
+		if tt.transcoder != nil {
+			c.transcoder = tt.transcoder
+		}
+		err = c.retranscodeVideo(w, r)
+		if !errors.Is(err, tt.expectedErr) && fmt.Sprint(err) != fmt.Sprint(tt.expectedErr) {
+			t.Errorf("case %d: expected error (%v), got error (%v)", i, tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if w.Code != http.StatusAccepted {
+			t.Errorf("case %d: expected status code %d, got %d", i, http.StatusAccepted, w.Code)
+		}
+		var resp VideoResponse
+		if err = json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != entity.StatusTranscoding || resp.Profile != tt.expectedProfile || resp.Transcode == nil || resp.Transcode.JobId != "job" {
+			t.Errorf("case %d: expected video transcoding by job with profile (%s), got %+v", i, tt.expectedProfile, resp)
+		}
+		if tt.transcoder.jobs != 1 {
+			t.Errorf("case %d: expected 1 job, got %d jobs", i, tt.transcoder.jobs)
+		}
+	}
+}
+
+type mockTranscoder struct {
+	jobs int
+	err  error
+}
+
+func (t *mockTranscoder) Transcode(video *entity.Video) (*entity.TranscodeJob, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	t.jobs++
+	return &entity.TranscodeJob{Id: "job"}, nil
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+	tusUploadPath = "/upload/molpastream/v1/tus"
+	// The status code responds when the checksum of the request body mismatches.
+	statusChecksumMismatch = 460
+)
+
+// The hash algorithms supported by the checksum extension.
+var tusChecksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// Wrap the handler of tus protocol, the protocol version is checked in every request except OPTIONS.
+func tusResumable(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			replyJSON(w, &appError{http.StatusPreconditionFailed, "unsupported version of tus protocol"}, http.StatusPreconditionFailed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Respond the capabilities of the tus server.
+func (c *controller) tusOptions(w http.ResponseWriter, r *http.Request) error {
+	algorithms := make([]string, 0, len(tusChecksumAlgorithms))
+	for name := range tusChecksumAlgorithms {
+		algorithms = append(algorithms, name)
+	}
+	sort.Strings(algorithms)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(algorithms, ","))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Create a new video uploaded by tus protocol.
+// The title, description and filetype of Upload-Metadata header are saved as the video fields, others are saved as metadata.
+func (c *controller) tusCreateUpload(w http.ResponseWriter, r *http.Request) error {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		return &appError{http.StatusBadRequest, "Upload-Defer-Length header is not supported"}
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		return &appError{http.StatusBadRequest, "Upload-Length header must be required"}
+	}
+	if size <= 0 {
+		return &appError{http.StatusBadRequest, "size must be greater than 0 bytes"}
+	}
+	if size > maxUploadSize {
+		return &appError{http.StatusRequestEntityTooLarge, fmt.Sprintf("size must not exceed %d bytes", int64(maxUploadSize))}
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	expected, err := parseChecksum(r.Header)
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	fields := make(map[string]string)
+	for _, key := range []string{"title", "description", "filetype", "profile"} {
+		fields[key] = metadata[key]
+		delete(metadata, key)
+	}
+	if fields["filetype"] == "" {
+		fields["filetype"] = "application/octet-stream"
+	}
+	profile, err := transcodeProfile(fields["profile"])
+	if err != nil {
+		return err
+	}
+	video := entity.NewVideo(uuid.New().String(), fields["title"], fields["description"], fields["filetype"], size, nil, metadata)
+	video.Profile = profile
+	video.ExpectedSHA256 = expected.SHA256
+	uploadId, err := c.uploader.CreateMultipart(video.Id)
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	video.NewUpload(uploadId, time.Now().Add(entity.UploadSessionTTL))
+	video.Upload.ChunkSize = uploadChunkSize(size)
+	if err = transition(video, entity.StatusUploading); err != nil {
+		return err
+	}
+	if err = c.video_repo.Save(video); err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	w.Header().Set("Location", tusUploadPath+"/"+video.Id)
+	w.Header().Set("Upload-Expires", video.Upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// Get the video uploaded by tus protocol which can receive the bytes.
+func (c *controller) tusVideo(id string) (*entity.Video, error) {
+	if id == "" {
+		return nil, &appError{http.StatusBadRequest, "video ID must be required"}
+	}
+	video, err := c.video_repo.GetById(id)
+	if err != nil {
+		return nil, &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if video == nil {
+		return nil, &appError{http.StatusNotFound, "video ID does not exist"}
+	}
+	if video.Status == entity.StatusDeleted {
+		return nil, &appError{http.StatusGone, "video has been deleted"}
+	}
+	if video.Upload == nil {
+		return nil, &appError{http.StatusBadRequest, "video is not uploaded by resumable upload"}
+	}
+	if video.IsUploadExpired(time.Now()) {
+		return nil, &appError{http.StatusGone, "upload session has expired"}
+	}
+	return video, nil
+}
+
+// Respond the offset of the upload, which is the number of contiguous bytes the server has received.
+func (c *controller) tusUploadOffset(w http.ResponseWriter, r *http.Request) error {
+	video, err := c.tusVideo(mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	setTusUploadOffset(w, video)
+	w.Header().Set("Upload-Length", strconv.FormatInt(video.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Append the request body to the upload at the offset given by Upload-Offset header.
+// The body is split into the parts aligned to the chunk size of resumable upload,
+// trailing bytes less than the chunk size are discarded unless they end the file,
+// and the client resumes from the offset in the response.
+func (c *controller) tusPatchUpload(w http.ResponseWriter, r *http.Request) error {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return &appError{http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream"}
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return &appError{http.StatusBadRequest, "Upload-Offset header must be required"}
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return &appError{http.StatusBadRequest, "Content-Length header must be required"}
+	}
+	checksum, err := parseTusChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		return &appError{http.StatusBadRequest, err.Error()}
+	}
+	video, err := c.tusVideo(mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	if video.Status != entity.StatusUploading {
+		return &appError{http.StatusConflict, fmt.Sprintf("video cannot be uploaded in %s status", video.Status)}
+	}
+	if offset != video.Upload.Received() {
+		return &appError{http.StatusConflict, "Upload-Offset mismatches the offset of the upload"}
+	}
+	if offset+size > video.Size {
+		return &appError{http.StatusBadRequest, "request body exceeds the length of the upload"}
+	}
+	chunkSize := sessionChunkSize(video.Upload)
+	if size > 0 && size < chunkSize && offset+size < video.Size {
+		return &appError{http.StatusBadRequest, fmt.Sprintf("size must be at least %d bytes", chunkSize)}
+	}
+	// Hash the body if it continues the running digest of the file.
+	var writers []io.Writer
+	digest := video.Upload.ResumableDigest()
+	h, err := digest.Resume()
+	if err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if digest.Offset == offset {
+		writers = append(writers, h)
+	} else {
+		digest = nil
+	}
+	if checksum != nil {
+		writers = append(writers, checksum.hash)
+	}
+	body := io.TeeReader(r.Body, io.MultiWriter(writers...))
+	// The parts are added at once after the checksum of the body is verified,
+	// otherwise each part is added once it was uploaded in case the request is interrupted.
+	var parts []*entity.Part
+	for remaining := size; remaining > 0; {
+		n := remaining
+		if n > maxUploadChunkSize {
+			n = maxUploadChunkSize - maxUploadChunkSize%chunkSize
+		}
+		if offset+n < video.Size {
+			n -= n % chunkSize
+		}
+		if n == 0 {
+			break
+		}
+		part, err := c.uploader.UploadPart(video.Id, video.Upload.Id, io.LimitReader(body, n), n, offset/chunkSize+1, entity.Checksum{})
+		if err != nil {
+			return &appError{http.StatusInternalServerError, err.Error()}
+		}
+		part.Offset, part.Size = offset, n
+		if digest != nil {
+			if digest, err = digest.Advance(h, part); err != nil {
+				return &appError{http.StatusInternalServerError, err.Error()}
+			}
+		}
+		parts = append(parts, part)
+		if checksum == nil {
+			if video, err = c.addUploadParts(video, parts, digest); err != nil {
+				return err
+			}
+			parts = nil
+		}
+		offset += n
+		remaining -= n
+	}
+	// Read the discarded bytes to verify the checksum of the entire body.
+	if _, err = io.Copy(io.Discard, body); err != nil {
+		return &appError{http.StatusInternalServerError, err.Error()}
+	}
+	if checksum != nil {
+		if !checksum.verify() {
+			return &appError{statusChecksumMismatch, "checksum of the request body mismatched"}
+		}
+		if video, err = c.addUploadParts(video, parts, digest); err != nil {
+			return err
+		}
+	}
+	if video, err = c.completeMultipart(video); err != nil {
+		return err
+	}
+	setTusUploadOffset(w, video)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Set the offset and the expiration of the upload to the response headers.
+func setTusUploadOffset(w http.ResponseWriter, video *entity.Video) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(video.Upload.Received(), 10))
+	if !video.IsUploaded() {
+		w.Header().Set("Upload-Expires", video.Upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Parse the Upload-Metadata header, which consists of comma-separated key and base64-encoded value pairs.
+func parseTusMetadata(h string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if h == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(h, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+		var value []byte
+		if len(fields) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, errors.New("invalid Upload-Metadata header")
+			}
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// The checksum of the request body given by Upload-Checksum header.
+type tusChecksum struct {
+	hash     hash.Hash
+	expected []byte
+}
+
+// Verify the hash written with the request body matches the expected checksum.
+func (c *tusChecksum) verify() bool {
+	return string(c.hash.Sum(nil)) == string(c.expected)
+}
+
+// Parse the Upload-Checksum header, which consists of the algorithm and the base64-encoded checksum.
+func parseTusChecksum(h string) (*tusChecksum, error) {
+	if h == "" {
+		return nil, nil
+	}
+	fields := strings.Fields(h)
+	if len(fields) != 2 {
+		return nil, errors.New("invalid Upload-Checksum header")
+	}
+	newHash, ok := tusChecksumAlgorithms[fields[0]]
+	if !ok {
+		return nil, errors.New("unsupported checksum algorithm")
+	}
+	expected, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, errors.New("invalid Upload-Checksum header")
+	}
+	return &tusChecksum{newHash(), expected}, nil
+}
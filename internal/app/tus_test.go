@@ -0,0 +1,179 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+func TestTusResumable(t *testing.T) {
+	tests := []struct {
+		method       string
+		version      string
+		expectedCode int
+	}{
+		{"OPTIONS", "", http.StatusNoContent},
+		{"POST", "", http.StatusPreconditionFailed},
+		{"POST", "0.2.2", http.StatusPreconditionFailed},
+		{"POST", tusVersion, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest(tt.method, tusUploadPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Tus-Resumable", tt.version)
+		w := httptest.NewRecorder()
+		tusResumable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+		if w.Code != tt.expectedCode {
+			t.Errorf("expected status code (%d) of %s %q, got status code (%d)", tt.expectedCode, tt.method, tt.version, w.Code)
+		}
+		if w.Header().Get("Tus-Resumable") != tusVersion {
+			t.Errorf("expected Tus-Resumable header (%s), got (%s)", tusVersion, w.Header().Get("Tus-Resumable"))
+		}
+	}
+}
+
+func TestTusCreateUpload(t *testing.T) {
+	tests := []struct {
+		headers     http.Header
+		expectedErr error
+	}{
+		{map[string][]string{}, errors.New("Upload-Length header must be required")},
+		{map[string][]string{"Upload-Defer-Length": {"1"}}, errors.New("Upload-Defer-Length header is not supported")},
+		{map[string][]string{"Upload-Length": {"0"}}, errors.New("size must be greater than 0 bytes")},
+		{map[string][]string{"Upload-Length": {"104857600001"}}, fmt.Errorf("size must not exceed %d bytes", int64(maxUploadSize))},
+		{map[string][]string{"Upload-Length": {"1048576"}, "Upload-Metadata": {"title foo!"}}, errors.New("invalid Upload-Metadata header")},
+		{map[string][]string{"Upload-Length": {"1048576"}, "Upload-Metadata": {"title Zm9v,filetype dmlkZW8vbXA0,is_private"}}, nil},
+		{map[string][]string{"Upload-Length": {"1048576"}, "Upload-Metadata": {"title Zm9v,profile Zm9v"}}, errors.New(`unknown transcode profile "foo"`)},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("POST", tusUploadPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = tt.headers
+		w := httptest.NewRecorder()
+		repo := &mockVideoRepoistory{}
+		c := &controller{repo, &mockUploader{}, &mockDownloader{}}
+		err = c.tusCreateUpload(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if w.Header().Get("Location") != tusUploadPath+"/"+repo.video.Id {
+			t.Errorf("expected location (%s), got location (%s)", tusUploadPath+"/"+repo.video.Id, w.Header().Get("Location"))
+		}
+		if repo.video.Title != "foo" || repo.video.ContentType != "video/mp4" {
+			t.Errorf("expected title (foo) and content type (video/mp4), got (%s) and (%s)", repo.video.Title, repo.video.ContentType)
+		}
+		if !reflect.DeepEqual(repo.video.Metadata, map[string]string{"is_private": ""}) {
+			t.Errorf("expected metadata (map[is_private:]), got metadata (%v)", repo.video.Metadata)
+		}
+	}
+}
+
+func TestTusPatchUpload(t *testing.T) {
+	const size = 12582912
+	sum := sha1.Sum(make([]byte, 5242880))
+	tests := []struct {
+		offsets         []int64
+		lengths         []int64
+		headers         http.Header
+		expectedOffsets []int64
+		expectedErr     error
+	}{
+		{[]int64{0, 5242880, 10485760}, []int64{5242880, 5242880, 2097152}, map[string][]string{}, []int64{5242880, 10485760, 12582912}, nil},
+		{[]int64{0}, []int64{12582912}, map[string][]string{}, []int64{12582912}, nil},
+		{[]int64{0, 5242880}, []int64{6000000, 7340032}, map[string][]string{}, []int64{5242880, 12582912}, nil},
+		{[]int64{0, 0}, []int64{5242880, 5242880}, map[string][]string{}, []int64{5242880}, errors.New("Upload-Offset mismatches the offset of the upload")},
+		{[]int64{0}, []int64{1048576}, map[string][]string{}, nil, fmt.Errorf("size must be at least %d bytes", repository.MinPartSize)},
+		{[]int64{0}, []int64{12582913}, map[string][]string{}, nil, errors.New("request body exceeds the length of the upload")},
+		{[]int64{0}, []int64{5242880}, map[string][]string{"Upload-Checksum": {"crc32 AAAAAA=="}}, nil, errors.New("unsupported checksum algorithm")},
+		{[]int64{0}, []int64{5242880}, map[string][]string{"Upload-Checksum": {"sha1 " + base64.StdEncoding.EncodeToString(sum[:])}}, []int64{5242880}, nil},
+		{[]int64{0}, []int64{5242880}, map[string][]string{"Upload-Checksum": {"sha1 AAAAAA=="}}, nil, errors.New("checksum of the request body mismatched")},
+		{[]int64{0}, []int64{5242880}, map[string][]string{"Content-Type": {"application/octet-stream"}}, nil, errors.New("Content-Type must be application/offset+octet-stream")},
+	}
+	for _, tt := range tests {
+		video := &entity.Video{Id: "1", Size: size, Status: entity.StatusUploading, ExpectedSHA256: sha256Base64(make([]byte, size))}
+		video.NewUpload("1", time.Now().Add(time.Hour))
+		video.Upload.ChunkSize = uploadChunkSize(size)
+		repo, uploader := &mockVideoRepoistory{video}, &mockUploader{}
+		c := &controller{repo, uploader, &mockDownloader{}}
+		var err error
+		for i, offset := range tt.offsets {
+			r, _ := http.NewRequest("PATCH", tusUploadPath+"/1", bytes.NewBuffer(make([]byte, tt.lengths[i])))
+			r.Header = map[string][]string{
+				"Content-Length": {strconv.FormatInt(tt.lengths[i], 10)},
+				"Content-Type":   {"application/offset+octet-stream"},
+				"Upload-Offset":  {strconv.FormatInt(offset, 10)},
+			}
+			for k, v := range tt.headers {
+				r.Header[k] = v
+			}
+			r = mux.SetURLVars(r, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+			if err = c.tusPatchUpload(w, r); err != nil {
+				break
+			}
+			if w.Header().Get("Upload-Offset") != strconv.FormatInt(tt.expectedOffsets[i], 10) {
+				t.Errorf("expected offset (%d) of patch at %d, got offset (%s)", tt.expectedOffsets[i], offset, w.Header().Get("Upload-Offset"))
+			}
+		}
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if err == nil && repo.video.Upload.Received() != tt.expectedOffsets[len(tt.expectedOffsets)-1] {
+			t.Errorf("expected received (%d), got received (%d)", tt.expectedOffsets[len(tt.expectedOffsets)-1], repo.video.Upload.Received())
+		}
+		if repo.video.IsUploaded() && (repo.video.Status != entity.StatusUploaded || repo.video.SHA256 != repo.video.ExpectedSHA256) {
+			t.Errorf("expected completed upload with checksum (%s), got status (%s) and checksum (%s)", repo.video.ExpectedSHA256, repo.video.Status, repo.video.SHA256)
+		}
+	}
+}
+
+func TestTusUploadOffset(t *testing.T) {
+	tests := []struct {
+		video          *entity.Video
+		expectedOffset string
+		expectedErr    error
+	}{
+		{nil, "", errors.New("video ID does not exist")},
+		{&entity.Video{Status: entity.StatusDeleted, Upload: &entity.UploadProgress{Id: "1"}}, "", errors.New("video has been deleted")},
+		{&entity.Video{Status: entity.StatusUploading}, "", errors.New("video is not uploaded by resumable upload")},
+		{&entity.Video{Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: time.Now().Add(-time.Hour)}}, "", errors.New("upload session has expired")},
+		{&entity.Video{Size: 1048576, Status: entity.StatusUploading, Upload: &entity.UploadProgress{Id: "1", ExpiresAt: time.Now().Add(time.Hour), Parts: map[string]*entity.Part{"0": {Offset: 0, Size: 524288}}}}, "524288", nil},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("HEAD", tusUploadPath+"/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		c := &controller{&mockVideoRepoistory{tt.video}, &mockUploader{}, &mockDownloader{}}
+		err = c.tusUploadOffset(w, r)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v), got error (%v)", tt.expectedErr, err)
+		}
+		if w.Header().Get("Upload-Offset") != tt.expectedOffset {
+			t.Errorf("expected offset (%s), got offset (%s)", tt.expectedOffset, w.Header().Get("Upload-Offset"))
+		}
+	}
+}
@@ -1,16 +1,140 @@
 package app
 
+import (
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
 type VideoRequest struct {
 	Title       string            `json:"title"`
 	Description string            `json:"description"`
 	Tags        []string          `json:"tags"`
 	Metadata    map[string]string `json:"metadata"`
+	Profile     string            `json:"profile"` // The name of the transcode profile, the default profile is used if empty.
+}
+
+// The fields to update a video, omitted fields are left unchanged.
+type VideoPatchRequest struct {
+	Title       *string            `json:"title"`
+	Description *string            `json:"description"`
+	Tags        *[]string          `json:"tags"`
+	Metadata    *map[string]string `json:"metadata"`
 }
 
 type VideoResponse struct {
-	Id          string            `json:"id"`
-	Description string            `json:"description"`
-	Tags        []string          `json:"tags"`
-	Metadata    map[string]string `json:"metadata"`
-	Status      string            `json:"status"`
+	Id          string             `json:"id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	ContentType string             `json:"contentType"`
+	Size        int64              `json:"size"`
+	Tags        []string           `json:"tags"`
+	Metadata    map[string]string  `json:"metadata"`
+	Status      string             `json:"status"`
+	Profile     string             `json:"profile,omitempty"`
+	Upload      UploadResponse     `json:"upload"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+	UploadedAt  *time.Time         `json:"uploadedAt,omitempty"`
+	Transcode   *TranscodeResponse `json:"transcode,omitempty"`
+	HLS         *StreamResponse    `json:"hls,omitempty"`
+	DASH        *StreamResponse    `json:"dash,omitempty"`
+}
+
+// The progress of video upload.
+type UploadResponse struct {
+	Received int64 `json:"received"` // The number of contiguous bytes have been received.
+	Total    int64 `json:"total"`    // The size of the video.
+}
+
+// The result of the latest transcoding job of the video.
+type TranscodeResponse struct {
+	JobId        string     `json:"jobId"`
+	ErrorCode    int64      `json:"errorCode,omitempty"`
+	ErrorMessage string     `json:"errorMessage,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+}
+
+// The stream of the video, the master playlist or manifest and the media playlists are given by the paths served by the API.
+type StreamResponse struct {
+	Playlist   string              `json:"playlist"`
+	Renditions []RenditionResponse `json:"renditions"`
+}
+
+type RenditionResponse struct {
+	Width    int64  `json:"width,omitempty"`
+	Height   int64  `json:"height,omitempty"`
+	Bitrate  int64  `json:"bitrate"`
+	Codec    string `json:"codec"`
+	Playlist string `json:"playlist,omitempty"`
+}
+
+func newStreamResponse(id, format string, stream *entity.Stream) *StreamResponse {
+	resp := &StreamResponse{Playlist: streamFilePath(id, format, stream.Playlist), Renditions: []RenditionResponse{}}
+	for _, r := range stream.Renditions {
+		rendition := RenditionResponse{r.Width, r.Height, r.Bitrate, r.Codec, ""}
+		if r.Playlist != "" {
+			rendition.Playlist = streamFilePath(id, format, r.Playlist)
+		}
+		resp.Renditions = append(resp.Renditions, rendition)
+	}
+	return resp
+}
+
+func newVideoResponse(video *entity.Video) VideoResponse {
+	resp := VideoResponse{
+		Id:          video.Id,
+		Title:       video.Title,
+		Description: video.Description,
+		ContentType: video.ContentType,
+		Size:        video.Size,
+		Tags:        video.Tags,
+		Metadata:    video.Metadata,
+		Status:      video.Status,
+		Profile:     video.Profile,
+		Upload:      UploadResponse{video.Received(), video.Size},
+		CreatedAt:   video.CreatedAt,
+		UpdatedAt:   video.UpdatedAt,
+	}
+	if !video.UploadedAt.IsZero() {
+		resp.UploadedAt = &video.UploadedAt
+	}
+	if job := video.Transcode; job != nil {
+		resp.Transcode = &TranscodeResponse{JobId: job.Id, ErrorCode: job.ErrorCode, ErrorMessage: job.ErrorMessage, StartedAt: job.StartedAt}
+		if !job.FinishedAt.IsZero() {
+			resp.Transcode.FinishedAt = &job.FinishedAt
+		}
+	}
+	if video.HLS != nil && video.Status == entity.StatusReady {
+		resp.HLS = newStreamResponse(video.Id, "hls", video.HLS)
+	}
+	if video.DASH != nil && video.Status == entity.StatusReady {
+		resp.DASH = newStreamResponse(video.Id, "dash", video.DASH)
+	}
+	return resp
+}
+
+// The video is transcoded with its own transcode profile if the profile is not given.
+type TranscodeRequest struct {
+	Profile string `json:"profile"`
+}
+
+// The signed URLs are bound to the client IP if it is given, and the user authenticated by the gateway.
+type PlaybackRequest struct {
+	TTL      int64  `json:"ttl"` // The lifetime of the URLs in seconds.
+	ClientIP string `json:"clientIp"`
+}
+
+type PlaybackResponse struct {
+	MediaURL    string    `json:"mediaUrl"`
+	HLSURL      string    `json:"hlsUrl,omitempty"`
+	DASHURL     string    `json:"dashUrl,omitempty"`
+	ManifestURL string    `json:"manifestUrl,omitempty"` // Redirects to the manifest of the format accepted by the client.
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type VideoListResponse struct {
+	Items         []VideoResponse `json:"items"`
+	NextPageToken string          `json:"nextPageToken,omitempty"`
 }
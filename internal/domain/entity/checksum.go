@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"hash"
+)
+
+// The checksums of the content, which are encoded in base64.
+type Checksum struct {
+	MD5    string // The MD5 digest of the content.
+	SHA256 string // The SHA-256 digest of the content.
+}
+
+// The running SHA-256 digest of the contiguous parts from the beginning of the file.
+type Digest struct {
+	Offset int64    // The number of bytes have been hashed.
+	State  []byte   // The marshaled state of the hash.
+	ETags  []string // The entity tags of the parts have been hashed in order.
+}
+
+// Create the hash resumed from the state of the digest.
+func (d *Digest) Resume() (hash.Hash, error) {
+	h := sha256.New()
+	if len(d.State) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(d.State); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Get the digest advanced by the part, the hash must have been written with the content of the part.
+func (d *Digest) Advance(h hash.Hash, part *Part) (*Digest, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	etags := append(append([]string{}, d.ETags...), part.ETag)
+	return &Digest{Offset: d.Offset + part.Size, State: state, ETags: etags}, nil
+}
+
+// Get the SHA-256 checksum of the hash encoded in base64.
+func EncodeSHA256(h hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
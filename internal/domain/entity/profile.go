@@ -0,0 +1,76 @@
+package entity
+
+// The profile is used if the video was created without a transcode profile.
+const DefaultProfile = "standard"
+
+// The transcode profile defines the encoding ladder of the renditions the video is transcoded to.
+type Profile struct {
+	Name       string
+	Renditions []*Rendition // The video renditions in landscape orientation, ordered by the resolution.
+}
+
+// The transcode profiles keyed by the profile name.
+var profiles = map[string]*Profile{
+	"mobile": {"mobile", []*Rendition{
+		{Width: 640, Height: 360, Bitrate: 800000, Codec: "H_264"},
+		{Width: 960, Height: 540, Bitrate: 2000000, Codec: "H_264"},
+	}},
+	"standard": {"standard", []*Rendition{
+		{Width: 640, Height: 360, Bitrate: 1200000, Codec: "H_264"},
+		{Width: 960, Height: 540, Bitrate: 3500000, Codec: "H_264"},
+		{Width: 1280, Height: 720, Bitrate: 5000000, Codec: "H_264"},
+	}},
+	"premium-4k": {"premium-4k", []*Rendition{
+		{Width: 640, Height: 360, Bitrate: 1200000, Codec: "H_264"},
+		{Width: 960, Height: 540, Bitrate: 3500000, Codec: "H_264"},
+		{Width: 1280, Height: 720, Bitrate: 5000000, Codec: "H_264"},
+		{Width: 1920, Height: 1080, Bitrate: 8000000, Codec: "H_264"},
+		{Width: 2560, Height: 1440, Bitrate: 16000000, Codec: "H_264"},
+		{Width: 3840, Height: 2160, Bitrate: 25000000, Codec: "H_264"},
+	}},
+}
+
+// Get the transcode profile by the name, the default profile is returned if the name is empty.
+func GetProfile(name string) (*Profile, bool) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := profiles[name]
+	return p, ok
+}
+
+// Get the renditions of the profile which never exceed the resolution of the source video.
+// The renditions are rotated for the source in portrait orientation. The lowest rendition, or the first rendition
+// wider than the source, is scaled down to fit the source keeping its aspect ratio, and the renditions above it are
+// dropped. The entire ladder is returned if the resolution is unknown.
+func (p *Profile) Ladder(width, height int64) []*Rendition {
+	// The heights of the renditions are compared with the short side of the source, and the widths with the long side.
+	portrait, short, long := height > width, height, width
+	if portrait {
+		short, long = width, height
+	}
+	var ladder []*Rendition
+	for i, r := range p.Renditions {
+		rendition := *r
+		fits := r.Height <= short && r.Width <= long
+		if width > 0 && height > 0 && !fits {
+			if i > 0 && r.Height > short {
+				break
+			}
+			// The dimensions are rounded down to even numbers, which are required by the chroma subsampling.
+			if r.Width*short > long*r.Height {
+				rendition.Width, rendition.Height = long&^1, r.Height*long/r.Width&^1
+			} else {
+				rendition.Width, rendition.Height = r.Width*short/r.Height&^1, short&^1
+			}
+		}
+		if portrait {
+			rendition.Width, rendition.Height = rendition.Height, rendition.Width
+		}
+		ladder = append(ladder, &rendition)
+		if width > 0 && height > 0 && !fits {
+			break
+		}
+	}
+	return ladder
+}
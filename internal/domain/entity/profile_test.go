@@ -0,0 +1,45 @@
+package entity
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProfileLadder(t *testing.T) {
+	tests := []struct {
+		profile        string
+		width, height  int64
+		expectedLadder string
+	}{
+		{"", 0, 0, "[640x360 960x540 1280x720]"},
+		{"standard", 854, 480, "[640x360]"},
+		{"standard", 320, 240, "[320x180]"},
+		{"standard", 240, 320, "[180x320]"},
+		{"mobile", 176, 99, "[176x98]"},
+		{"standard", 1920, 1080, "[640x360 960x540 1280x720]"},
+		{"standard", 1080, 1920, "[360x640 540x960 720x1280]"},
+		{"mobile", 3840, 2160, "[640x360 960x540]"},
+		{"premium-4k", 3840, 2160, "[640x360 960x540 1280x720 1920x1080 2560x1440 3840x2160]"},
+		{"premium-4k", 2560, 1440, "[640x360 960x540 1280x720 1920x1080 2560x1440]"},
+		// The renditions wider than 4:3 sources are scaled down to the long side.
+		{"premium-4k", 1440, 1080, "[640x360 960x540 1280x720 1440x810]"},
+		{"premium-4k", 1080, 1440, "[360x640 540x960 720x1280 810x1440]"},
+		{"standard", 1000, 1000, "[640x360 960x540 1000x562]"},
+	}
+	for _, tt := range tests {
+		p, ok := GetProfile(tt.profile)
+		if !ok {
+			t.Fatalf("expected profile %q, got none", tt.profile)
+		}
+		var ladder []string
+		for _, r := range p.Ladder(tt.width, tt.height) {
+			ladder = append(ladder, fmt.Sprintf("%dx%d", r.Width, r.Height))
+		}
+		if fmt.Sprint(ladder) != tt.expectedLadder {
+			t.Errorf("Ladder(%d, %d) of profile %q = %v, want %s", tt.width, tt.height, tt.profile, ladder, tt.expectedLadder)
+		}
+	}
+	if _, ok := GetProfile("foo"); ok {
+		t.Errorf("expected unknown profile foo, got profile")
+	}
+}
@@ -0,0 +1,97 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The lifecycle status of the video.
+const (
+	StatusCreated     = "CREATED"     // The video was created, no bytes have been uploaded.
+	StatusUploading   = "UPLOADING"   // The resumable upload session is in progress.
+	StatusUploaded    = "UPLOADED"    // All bytes of the video were uploaded.
+	StatusTranscoding = "TRANSCODING" // The video is being transcoded to the streaming outputs.
+	StatusReady       = "READY"       // The streaming outputs of the video are ready.
+	StatusFailed      = "FAILED"      // The upload or the transcoding of the video failed.
+	StatusRejected    = "REJECTED"    // The video was rejected by the transcoder, e.g. the content is not a video.
+	StatusDeleted     = "DELETED"     // The video was deleted, which is the final status.
+)
+
+// The status of the videos stored before the lifecycle, which were created or being uploaded.
+const StatusLegacyProcessed = "PROCESSED"
+
+// The statuses each status can transition to.
+var transitions = map[string][]string{
+	StatusCreated:     {StatusUploading, StatusUploaded, StatusFailed, StatusDeleted},
+	StatusUploading:   {StatusUploaded, StatusFailed, StatusDeleted},
+	StatusUploaded:    {StatusUploading, StatusTranscoding, StatusFailed, StatusRejected, StatusDeleted},
+	StatusTranscoding: {StatusReady, StatusFailed, StatusRejected, StatusDeleted},
+	StatusReady:       {StatusTranscoding, StatusDeleted},
+	StatusFailed:      {StatusTranscoding, StatusDeleted},
+	StatusRejected:    {StatusTranscoding, StatusDeleted},
+}
+
+// The error is returned if the video cannot transition from its status to the given status.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// The record of a status transition of the video.
+type StatusChange struct {
+	From string
+	To   string
+	At   time.Time `dynamodbav:",unixtime"`
+}
+
+// Determine whether the status is a lifecycle status of the video.
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok || status == StatusDeleted
+}
+
+// Map the legacy status of the video stored before the lifecycle to its lifecycle status.
+// The videos with a multipart upload were being uploaded, the others were created without bytes.
+// The legacy upload sessions without deadline expire the session TTL after the video was last updated.
+func (v *Video) MigrateStatus() {
+	if v.Status != StatusLegacyProcessed {
+		return
+	}
+	v.Status = StatusCreated
+	if v.Upload != nil {
+		v.Status = StatusUploading
+		if v.Upload.ExpiresAt.IsZero() {
+			v.Upload.ExpiresAt = v.UpdatedAt.Add(UploadSessionTTL)
+		}
+	}
+}
+
+// Determine whether the video can transition from its status to the given status.
+func (v *Video) CanTransition(to string) bool {
+	for _, status := range transitions[v.Status] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition the video to the given status at the given time, and keep the change in the history.
+func (v *Video) Transition(to string, now time.Time) error {
+	if !v.CanTransition(to) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, v.Status, to)
+	}
+	v.History = append(v.History, &StatusChange{From: v.Status, To: to, At: now})
+	v.Status = to
+	switch to {
+	case StatusUploaded:
+		v.UploadedAt = now
+	case StatusUploading:
+		// The uploaded video returns to uploading if its parts cannot be assembled for now.
+		v.UploadedAt = time.Time{}
+	}
+	return nil
+}
+
+// Determine whether the uploaded content of the video is available.
+func (v *Video) IsAvailable() bool {
+	return v.Status == StatusUploaded || v.Status == StatusTranscoding || v.Status == StatusReady
+}
@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		statuses    []string
+		expectedErr error
+	}{
+		{[]string{StatusUploading, StatusUploaded, StatusTranscoding, StatusReady, StatusDeleted}, nil},
+		{[]string{StatusUploaded, StatusTranscoding, StatusRejected}, nil},
+		{[]string{StatusUploading, StatusFailed, StatusDeleted}, nil},
+		{[]string{StatusUploading, StatusUploaded, StatusUploading, StatusUploaded}, nil},
+		{[]string{StatusTranscoding}, ErrInvalidTransition},
+		{[]string{StatusUploading, StatusUploading}, ErrInvalidTransition},
+		{[]string{StatusDeleted, StatusUploading}, ErrInvalidTransition},
+		{[]string{StatusUploaded, StatusTranscoding, StatusReady, StatusFailed}, ErrInvalidTransition},
+		{[]string{StatusUploaded, StatusTranscoding, StatusFailed, StatusTranscoding, StatusReady, StatusTranscoding}, nil},
+	}
+	for _, tt := range tests {
+		video := NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		now := time.Now()
+		var err error
+		for _, status := range tt.statuses {
+			if err = video.Transition(status, now); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of transitions %v, got error (%v)", tt.expectedErr, tt.statuses, err)
+		}
+		if err != nil {
+			continue
+		}
+		// Every transition is kept in the history in order.
+		from := StatusCreated
+		for i, change := range video.History {
+			if change.From != from || change.To != tt.statuses[i] || !change.At.Equal(now) {
+				t.Errorf("expected change from %s to %s, got %+v", from, tt.statuses[i], change)
+			}
+			from = change.To
+		}
+		if len(video.History) != len(tt.statuses) || video.Status != from {
+			t.Errorf("expected status (%s) with %d changes, got status (%s) with %d changes", from, len(tt.statuses), video.Status, len(video.History))
+		}
+	}
+}
+
+func TestMigrateStatus(t *testing.T) {
+	tests := []struct {
+		status         string
+		upload         bool
+		expectedStatus string
+	}{
+		{StatusLegacyProcessed, false, StatusCreated},
+		{StatusLegacyProcessed, true, StatusUploading},
+		{StatusUploaded, false, StatusUploaded},
+		{StatusReady, true, StatusReady},
+	}
+	for _, tt := range tests {
+		video := NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		video.Status = tt.status
+		if tt.upload {
+			video.NewUpload("upload", time.Time{})
+		}
+		video.MigrateStatus()
+		if video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s) of %s video with upload (%v), got status (%s)", tt.expectedStatus, tt.status, tt.upload, video.Status)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// The error is returned if the result belongs to a transcoding job other than the job of the video.
+	ErrUnknownTranscodeJob = errors.New("unknown transcoding job of video")
+	// The error is returned if the video is transcoded again before all bytes of the video were uploaded.
+	ErrNotUploaded = errors.New("video has not been uploaded")
+)
+
+// The claim expires if no job has been recorded on it within the timeout, e.g. the claimer crashed before the job was
+// submitted, which outlasts the longest invocation of the AWS Lambda function.
+const TranscodeClaimTimeout = 15 * time.Minute
+
+// The claim of transcoding the source file of the video, so that only one job is submitted for each source file.
+type TranscodeClaim struct {
+	Source    string    // The version or the entity tag of the source file.
+	JobId     string    `dynamodbav:",omitempty"` // The ID of the job submitted by the claimer once it is recorded.
+	ClaimedAt time.Time `dynamodbav:",unixtime"`
+}
+
+// Check whether the claim is held at the given time, the claim is released once it expires without a job.
+func (c *TranscodeClaim) IsHeld(now time.Time) bool {
+	return c.JobId != "" || now.Before(c.ClaimedAt.Add(TranscodeClaimTimeout))
+}
+
+// The transcoding job launched for the video.
+type TranscodeJob struct {
+	Id           string    // The identifier of the job given by the transcoder.
+	ErrorCode    int64     // The error code of the failed job.
+	ErrorMessage string    // The error message of the failed job.
+	HLS          *Stream   // The HLS stream the job is producing.
+	DASH         *Stream   // The DASH stream the job is producing.
+	Outputs      []string  // The paths of the files produced by the job.
+	StartedAt    time.Time `dynamodbav:",unixtime"`
+	FinishedAt   time.Time `dynamodbav:",unixtime"` // The time when the job completed or failed.
+}
+
+// The stream of the transcoded video, which is made of the playlists stored in the bucket.
+type Stream struct {
+	Bucket     string       // The bucket stores the playlists and segments of the stream.
+	Playlist   string       // The key of the master playlist of HLS, or the manifest of DASH.
+	Renditions []*Rendition // The renditions listed in the master playlist or manifest.
+}
+
+// The rendition of the stream encoded in a resolution and bitrate.
+type Rendition struct {
+	Width    int64  // The width of the video in pixels, zero for audio renditions.
+	Height   int64  // The height of the video in pixels, zero for audio renditions.
+	Bitrate  int64  // The maximum bitrate in bits per second.
+	Codec    string // The codec of the rendition, e.g. H_264 or AAC.
+	Playlist string // The key of the media playlist of the rendition, empty for DASH streams.
+}
+
+// Mark the video as transcoding by the given job at the given time.
+func (v *Video) StartTranscode(job *TranscodeJob, now time.Time) error {
+	if err := v.Transition(StatusTranscoding, now); err != nil {
+		return err
+	}
+	job.StartedAt = now
+	v.Transcode = job
+	return nil
+}
+
+// Mark the video as transcoding by the given job again at the given time, e.g. with another transcode profile or after
+// the transcoding failed. The job supersedes the running job of the video, whose result is ignored,
+// and the streams of the video are kept until the job completes.
+func (v *Video) RestartTranscode(job *TranscodeJob, now time.Time) error {
+	if v.UploadedAt.IsZero() {
+		return ErrNotUploaded
+	}
+	if v.Status != StatusTranscoding {
+		if err := v.Transition(StatusTranscoding, now); err != nil {
+			return err
+		}
+	}
+	job.StartedAt = now
+	v.Transcode = job
+	return nil
+}
+
+// Claim the transcoding of the given source file of the video at the given time, false is returned if the source file
+// has been claimed. The expired claim of the uploaded video is taken over, as its job has never been recorded.
+func (v *Video) ClaimTranscode(source string, now time.Time) bool {
+	if c := v.TranscodeClaim; c != nil && c.Source == source && (v.Status != StatusUploaded || c.IsHeld(now)) {
+		return false
+	}
+	v.TranscodeClaim = &TranscodeClaim{Source: source, ClaimedAt: now}
+	return true
+}
+
+// Mark the video as ready with the outputs produced by the given job at the given time,
+// the streams produced by the job become the streams of the video.
+func (v *Video) CompleteTranscode(jobId string, outputs []string, now time.Time) error {
+	if v.Transcode == nil || v.Transcode.Id != jobId {
+		return ErrUnknownTranscodeJob
+	}
+	if err := v.Transition(StatusReady, now); err != nil {
+		return err
+	}
+	v.Transcode.Outputs, v.Transcode.FinishedAt = outputs, now
+	v.HLS, v.DASH = v.Transcode.HLS, v.Transcode.DASH
+	return nil
+}
+
+// Mark the video as failed with the error of the given job at the given time.
+func (v *Video) FailTranscode(jobId string, code int64, message string, now time.Time) error {
+	if v.Transcode == nil || v.Transcode.Id != jobId {
+		return ErrUnknownTranscodeJob
+	}
+	if err := v.Transition(StatusFailed, now); err != nil {
+		return err
+	}
+	v.Transcode.ErrorCode, v.Transcode.ErrorMessage, v.Transcode.FinishedAt = code, message, now
+	return nil
+}
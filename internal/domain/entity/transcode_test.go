@@ -0,0 +1,126 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTranscode(t *testing.T) {
+	tests := []struct {
+		jobId          string
+		failed         bool
+		expectedStatus string
+		expectedErr    error
+	}{
+		{"1", false, StatusReady, nil},
+		{"1", true, StatusFailed, nil},
+		{"2", false, StatusTranscoding, ErrUnknownTranscodeJob},
+		{"2", true, StatusTranscoding, ErrUnknownTranscodeJob},
+	}
+	for _, tt := range tests {
+		video := NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		now := time.Now()
+		if err := video.Transition(StatusUploaded, now); err != nil {
+			t.Fatal(err)
+		}
+		stream := &Stream{Bucket: "bucket", Playlist: "1.m3u8", Renditions: []*Rendition{{640, 360, 1200000, "H_264", "1_360.m3u8"}}}
+		dash := &Stream{Bucket: "bucket", Playlist: "1.mpd", Renditions: []*Rendition{{Width: 640, Height: 360, Bitrate: 1200000, Codec: "H_264"}}}
+		if err := video.StartTranscode(&TranscodeJob{Id: "1", HLS: stream, DASH: dash}, now); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if tt.failed {
+			err = video.FailTranscode(tt.jobId, 1010, "unsupported input", now)
+		} else {
+			err = video.CompleteTranscode(tt.jobId, []string{"s3://bucket/1/1.m3u8"}, now)
+		}
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of job %s, got error (%v)", tt.expectedErr, tt.jobId, err)
+		}
+		if video.Status != tt.expectedStatus {
+			t.Errorf("expected status (%s) of job %s, got status (%s)", tt.expectedStatus, tt.jobId, video.Status)
+		}
+		if err == nil && (!video.Transcode.FinishedAt.Equal(now) || tt.failed != (video.Transcode.ErrorCode != 0) || tt.failed == (len(video.Transcode.Outputs) > 0)) {
+			t.Errorf("expected finished job with error or outputs, got %+v", video.Transcode)
+		}
+		// The streams are only playable once the job completed.
+		if (video.Status == StatusReady) != (video.HLS == stream) || (video.Status == StatusReady) != (video.DASH == dash) {
+			t.Errorf("expected streams of %s video, got streams %+v and %+v", video.Status, video.HLS, video.DASH)
+		}
+	}
+	// The transcoding result is only applied once.
+	video := &Video{Status: StatusReady, Transcode: &TranscodeJob{Id: "1"}}
+	if err := video.FailTranscode("1", 1010, "", time.Now()); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected error (%v), got error (%v)", ErrInvalidTransition, err)
+	}
+}
+
+func TestRestartTranscode(t *testing.T) {
+	tests := []struct {
+		statuses    []string
+		expectedErr error
+	}{
+		{[]string{StatusUploaded, StatusTranscoding}, nil},
+		{[]string{StatusUploaded, StatusTranscoding, StatusReady}, nil},
+		{[]string{StatusUploaded, StatusTranscoding, StatusFailed}, nil},
+		{[]string{StatusUploaded, StatusDeleted}, ErrInvalidTransition},
+		{[]string{StatusUploading, StatusFailed}, ErrNotUploaded},
+	}
+	for _, tt := range tests {
+		video := NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+		now := time.Now()
+		for _, status := range tt.statuses {
+			if err := video.Transition(status, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		video.Transcode = &TranscodeJob{Id: "1"}
+		err := video.RestartTranscode(&TranscodeJob{Id: "2"}, now)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of video transcoded again after %v, got error (%v)", tt.expectedErr, tt.statuses, err)
+		}
+		if err != nil {
+			continue
+		}
+		// The result of the superseded job is ignored.
+		if video.Status != StatusTranscoding || video.Transcode.Id != "2" {
+			t.Errorf("expected video transcoding by job 2, got status (%s) and job %+v", video.Status, video.Transcode)
+		}
+		if err = video.CompleteTranscode("1", nil, now); !errors.Is(err, ErrUnknownTranscodeJob) {
+			t.Errorf("expected error (%v), got error (%v)", ErrUnknownTranscodeJob, err)
+		}
+	}
+}
+
+func TestClaimTranscode(t *testing.T) {
+	video := NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+	video.Status = StatusUploaded
+	now := time.Now()
+	for i, tt := range []struct {
+		source   string
+		now      time.Time
+		expected bool
+	}{
+		{"abc", now, true},
+		{"abc", now, false},
+		{"def", now, true},
+		{"abc", now, true},
+		// The claim without a job is taken over once it expires.
+		{"abc", now.Add(TranscodeClaimTimeout - time.Second), false},
+		{"abc", now.Add(TranscodeClaimTimeout), true},
+	} {
+		if claimed := video.ClaimTranscode(tt.source, tt.now); claimed != tt.expected {
+			t.Errorf("claim %d of source %s = %v, want %v", i, tt.source, claimed, tt.expected)
+		}
+	}
+	// The claim is held by the job recorded on it, and the claim of the video transcoded is never taken over.
+	video.TranscodeClaim.JobId = "job"
+	if video.ClaimTranscode("abc", now.Add(time.Hour)) {
+		t.Errorf("expected claim of source abc held by job")
+	}
+	video.TranscodeClaim.JobId, video.Status = "", StatusTranscoding
+	if video.ClaimTranscode("abc", now.Add(time.Hour)) {
+		t.Errorf("expected claim of source abc held by video in %s status", video.Status)
+	}
+}
@@ -1,16 +1,18 @@
 package entity
 
-const (
-	UploadedStatusCompleted = "UPLOADED"
-	UploadedStatusDeleted   = "DELETED"
-	UploadedStatusFailed    = "FAILED"
-	UploadedStatusProcessed = "PROCESSED"
-	UploadedStatusRejected  = "REJECTED"
+import (
+	"sort"
+	"strconv"
+	"time"
 )
 
+// The lifetime of the upload sessions.
+const UploadSessionTTL = 7 * 24 * time.Hour
+
 // The entity of stream video.
 type Video struct {
 	Id          string
+	Version     int64 // The version of the stored video, which increases whenever the video is written.
 	ContentType string
 	Description string
 	Metadata    map[string]string
@@ -18,7 +20,21 @@ type Video struct {
 	Title       string
 	Size        int64
 	Status      string
+	Profile     string          // The name of the transcode profile of the video.
+	History     []*StatusChange // The status transitions of the video in order.
 	Upload      *UploadProgress
+	Transcode   *TranscodeJob // The latest transcoding job of the video.
+	// The claim of transcoding the latest source file, which is kept even if the video is transcoded again.
+	TranscodeClaim *TranscodeClaim
+	HLS            *Stream // The HLS stream of the video once it is ready.
+	DASH           *Stream // The DASH stream of the video once it is ready.
+	// The SHA-256 checksum of the entire file given by the client, encoded in base64.
+	ExpectedSHA256 string
+	// The SHA-256 checksum of the uploaded file, encoded in base64.
+	SHA256     string
+	CreatedAt  time.Time `dynamodbav:",unixtime"`
+	UpdatedAt  time.Time `dynamodbav:",unixtime"`
+	UploadedAt time.Time `dynamodbav:",unixtime"` // The time when all bytes of the video were uploaded.
 }
 
 func NewVideo(id, title, description, contentType string, size int64, tags []string, metadata map[string]string) *Video {
@@ -28,34 +44,136 @@ func NewVideo(id, title, description, contentType string, size int64, tags []str
 		Description: description,
 		ContentType: contentType,
 		Size:        size,
-		Status:      UploadedStatusProcessed,
+		Status:      StatusCreated,
 		Tags:        tags,
 		Metadata:    metadata,
 	}
 }
 
-func (v *Video) NewUpload(id string) { v.Upload = &UploadProgress{Id: id} }
+// Start a multipart upload of the video, the upload session expires at the given time.
+func (v *Video) NewUpload(id string, expiresAt time.Time) {
+	v.Upload = &UploadProgress{Id: id, Last: -1, Parts: map[string]*Part{}, ExpiresAt: expiresAt, Digest: &Digest{}}
+}
 
-// Add a file part to video for multipart upload.
+// Add a file part to video for multipart upload, the part uploaded at the same offset is replaced.
 func (v *Video) AddUploadPart(part *Part) {
-	v.Upload.Parts = append(v.Upload.Parts, part)
+	if v.Upload.Parts == nil {
+		v.Upload.Parts = map[string]*Part{}
+	}
+	v.Upload.Parts[part.Key()] = part
+	v.Upload.First = 0
+	v.Upload.Last = v.Upload.Received() - 1
+}
+
+// Determine whether all bytes of the video have been uploaded.
+func (v *Video) IsUploaded() bool {
+	return v.Upload != nil && v.Upload.Received() >= v.Size
+}
+
+// Determine whether the multipart upload in progress has expired at the given time.
+func (v *Video) IsUploadExpired(now time.Time) bool {
+	return v.Upload != nil && v.Status == StatusUploading && v.Upload.IsExpired(now)
 }
 
-// Mark the upload status to the video.
-func (v *Video) SetStatus(status string) {
-	v.Status = status
+// Update the timestamps of the video which is modified at the given time.
+func (v *Video) Touch(now time.Time) {
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = now
+	}
+	v.UpdatedAt = now
+}
+
+// Get the number of bytes of the video have been uploaded.
+func (v *Video) Received() int64 {
+	if v.Upload != nil {
+		return v.Upload.Received()
+	}
+	if v.IsAvailable() {
+		return v.Size
+	}
+	return 0
 }
 
 // The uplaod progress is used for multipart upload.
 type UploadProgress struct {
-	Id    string  // The upload identifier in multipart upload.
-	First int64   // The first byte was uploaded to the storage.
-	Last  int64   // The last byte was uploaded to the storage.
-	Parts []*Part // A set of parts in multipart upload.
+	Id    string           // The upload identifier in multipart upload.
+	First int64            // The first byte was uploaded to the storage.
+	Last  int64            // The last byte of the contiguous bytes was uploaded to the storage.
+	Parts map[string]*Part // A set of parts in multipart upload keyed by the byte offset.
+	// The granularity of the chunks, which start at multiples of it and are numbered by it.
+	ChunkSize int64
+	// The deadline of the upload session, the session never expires if it is zero.
+	ExpiresAt time.Time `dynamodbav:",unixtime"`
+	// The running digest of the parts uploaded in order.
+	Digest *Digest
+}
+
+// Get the running digest if it covers the parts from the beginning of the file,
+// otherwise an empty digest is returned, e.g. the hashed parts have been replaced by retries.
+func (u *UploadProgress) ResumableDigest() *Digest {
+	if u.Digest == nil {
+		return &Digest{}
+	}
+	parts := u.SortedParts()
+	var offset int64
+	for i, etag := range u.Digest.ETags {
+		if i >= len(parts) || parts[i].Offset != offset || parts[i].ETag != etag {
+			return &Digest{}
+		}
+		offset += parts[i].Size
+	}
+	if offset != u.Digest.Offset {
+		return &Digest{}
+	}
+	return u.Digest
+}
+
+// Determine whether the upload session has expired at the given time.
+func (u *UploadProgress) IsExpired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// Get the number of contiguous bytes uploaded from the beginning of the file.
+func (u *UploadProgress) Received() int64 {
+	var n int64
+	for _, part := range u.SortedParts() {
+		if part.Offset > n {
+			break
+		}
+		if end := part.Offset + part.Size; end > n {
+			n = end
+		}
+	}
+	return n
+}
+
+// Determine whether the byte range overlaps a part uploaded at a different offset.
+func (u *UploadProgress) Overlaps(offset, size int64) bool {
+	for _, part := range u.Parts {
+		if part.Offset != offset && part.Offset < offset+size && offset < part.Offset+part.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the parts in multipart upload sorted by the byte offset.
+func (u *UploadProgress) SortedParts() []*Part {
+	parts := make([]*Part, 0, len(u.Parts))
+	for _, part := range u.Parts {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Offset < parts[j].Offset })
+	return parts
 }
 
 // The part portion of video data.
 type Part struct {
 	ETag       string // Entity tag for the uploaded object.
 	PartNumber int64  // Part number that identifies the part.
+	Offset     int64  // The byte offset of the part in the file.
+	Size       int64  // The size of the part in bytes.
 }
+
+// Get the key identifies the part in multipart upload.
+func (p *Part) Key() string { return strconv.FormatInt(p.Offset, 10) }
@@ -0,0 +1,10 @@
+package repository
+
+import "io"
+
+type Downloader interface {
+	// Download a byte range of the file from the storage.
+	Download(key string, start, length int64) (io.ReadCloser, error)
+	// Get the size of the file in the storage, ErrFileNotFound is returned if the file does not exist.
+	Size(key string) (int64, error)
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The error is returned if the checksum of the uploaded content mismatches the expected checksum.
+var ErrChecksumMismatch = errors.New("checksum of the uploaded content mismatched")
+
+// The error is returned if the parts of a multipart upload can never be assembled, e.g. the parts are missing.
+var ErrInvalidParts = errors.New("parts of the multipart upload cannot be assembled")
+
+// The error is returned if a part but the last one in a multipart upload is smaller than the minimum part size.
+var ErrPartTooSmall = fmt.Errorf("%w: part is smaller than %d bytes", ErrInvalidParts, MinPartSize)
+
+// The error is returned if the file does not exist in the storage.
+var ErrFileNotFound = errors.New("file does not exist")
+
+// The error is returned if the video to update does not exist.
+var ErrVideoNotFound = errors.New("video does not exist")
+
+// The error is returned if the transcoding of the source file of the video has been claimed.
+var ErrTranscodeClaimed = errors.New("transcoding of the source file has been claimed")
+
+// The error is returned if the video was written concurrently since it was read.
+// Callers are expected to read the video again and retry the write.
+type ConflictError struct {
+	Id      string // The video ID.
+	Version int64  // The version of the video to write.
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version %d of video %q is outdated", e.Version, e.Id)
+}
+
+// The error is returned if the cursor of listing videos cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor of video list")
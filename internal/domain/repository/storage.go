@@ -0,0 +1,7 @@
+package repository
+
+// The storage keeps video files, which supports both uploads and downloads.
+type Storage interface {
+	Uploader
+	Downloader
+}
@@ -0,0 +1,11 @@
+package repository
+
+import "github.com/molpadia/molpastream/internal/domain/entity"
+
+// The transcoder transcodes the uploaded videos to the streams of their transcode profiles.
+type Transcoder interface {
+	// Launch the job transcoding the source file of the video and return the job producing the streams.
+	// The job runs asynchronously, its result is reported to the video once the job finishes,
+	// so the caller is expected to mark the video as transcoding by the job.
+	Transcode(video *entity.Video) (*entity.TranscodeJob, error)
+}
@@ -1,14 +1,24 @@
 package repository
 
-import "github.com/molpadia/molpastream/internal/domain/entity"
+import (
+	"io"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+// The minimum size of the parts but the last one in a multipart upload, which is required by AWS S3.
+const MinPartSize = 5 << 20
 
 type Uploader interface {
-	// Initiates a multipart upload and return an upload ID from remote AWS S3 storage.
+	// Initiates a multipart upload and return an upload ID from the storage.
 	CreateMultipart(key string) (string, error)
-	// Mark the multipart upload as completd for the remote AWS S3 storage.
+	// Mark the multipart upload as completd for the storage.
+	// ErrInvalidParts is returned if the parts can never be assembled, other errors are temporary.
 	CompleteMultipart(key, uploadId string, parts []*entity.Part) error
-	// Upload an entire file to remote AWS S3 storage.
-	SimpleUpload(key string, body []byte) error
-	// Upload a file part to remote AWS S3 storage.
-	UploadPart(key, uploadId string, body []byte, length, partNumber int64) (*entity.Part, error)
+	// Abort the multipart upload and delete the uploaded parts from the storage.
+	AbortMultipart(key, uploadId string) error
+	// Stream an entire file of the given length to the storage, verifying the given checksums if any.
+	SimpleUpload(key string, body io.Reader, length int64, checksum entity.Checksum) error
+	// Stream a file part of the given length to the storage, verifying the given checksums if any.
+	UploadPart(key, uploadId string, body io.Reader, length, partNumber int64, checksum entity.Checksum) (*entity.Part, error)
 }
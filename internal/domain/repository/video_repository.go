@@ -1,10 +1,39 @@
 package repository
 
-import "github.com/molpadia/molpastream/internal/domain/entity"
+import (
+	"time"
 
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+// The criteria of listing videos, an empty field matches any video.
+type VideoFilter struct {
+	Status string // The upload status of videos, deleted videos are only listed if the status is given.
+	Tag    string // The tag which videos contain.
+}
+
+// The repository keeps the timestamps and the version of videos up to date whenever they are written.
 type VideoRepository interface {
 	// Get the video by the video ID.
 	GetById(id string) (*entity.Video, error)
-	// Save an entity to the persistence.
+	// List the videos matching the filter, starting after the cursor of the previous page.
+	// The cursor of the next page is empty if there are no more videos.
+	List(filter VideoFilter, cursor string, limit int) ([]*entity.Video, string, error)
+	// Save an entity to the persistence on condition that its version is the stored version, and increase the version.
+	// The ConflictError is returned if the video has been written since it was read.
 	Save(video *entity.Video) error
+	// Update the title, description, tags and metadata of an existing video, the video is refreshed as it is stored.
+	Update(video *entity.Video) error
+	// Mark the video as deleted, the video is kept in the persistence.
+	// The ConflictError is returned if the status of the video has been changed since it was read.
+	Delete(id string) error
+	// Add a part to the multipart upload of the video and return the updated video.
+	AddUploadPart(id, uploadId string, part *entity.Part) (*entity.Video, error)
+	// Save the running digest of the multipart upload of the video and return the updated video.
+	SaveUploadDigest(id, uploadId string, digest *entity.Digest) (*entity.Video, error)
+	// Claim the transcoding of the source file of the video identified by its version or entity tag, and return the
+	// updated video. ErrTranscodeClaimed is returned if the source file has been claimed.
+	ClaimTranscode(id, source string, now time.Time) (*entity.Video, error)
+	// Find the videos whose multipart upload in progress has expired at the given time.
+	FindExpiredUploads(now time.Time) ([]*entity.Video, error)
 }
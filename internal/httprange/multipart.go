@@ -0,0 +1,108 @@
+package httprange
+
+import (
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// The source which reads a byte range of the file content.
+type Source interface {
+	ReadRange(start, length int64) (io.ReadCloser, error)
+}
+
+// The adapter to allow the use of ordinary functions as the source.
+type SourceFunc func(start, length int64) (io.ReadCloser, error)
+
+// Read the byte range by calling the function.
+func (fn SourceFunc) ReadRange(start, length int64) (io.ReadCloser, error) {
+	return fn(start, length)
+}
+
+// Wrap the reader as a source which reads the byte range via ReadAt.
+func ReaderAtSource(ra io.ReaderAt) Source {
+	return SourceFunc(func(start, length int64) (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(ra, start, length)), nil
+	})
+}
+
+// The writer produces multipart/byteranges body for the multiple ranges.
+type MultipartWriter struct {
+	ranges      []Range
+	contentType string
+	size        int64
+	boundary    string
+}
+
+func NewMultipartWriter(ranges []Range, contentType string, size int64) *MultipartWriter {
+	return &MultipartWriter{
+		ranges:      ranges,
+		contentType: contentType,
+		size:        size,
+		boundary:    multipart.NewWriter(io.Discard).Boundary(),
+	}
+}
+
+// Get the value of Content-Type header for the multipart body.
+func (m *MultipartWriter) ContentType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+// Get the total length of the multipart body in bytes.
+func (m *MultipartWriter) Length() int64 {
+	var w countingWriter
+	mw := m.newWriter(&w)
+	for _, ra := range m.ranges {
+		mw.CreatePart(m.partHeader(ra))
+		w += countingWriter(ra.Length)
+	}
+	mw.Close()
+	return int64(w)
+}
+
+// Write the multipart body with the content of each range read from the source.
+func (m *MultipartWriter) Write(w io.Writer, src Source) error {
+	mw := m.newWriter(w)
+	for _, ra := range m.ranges {
+		part, err := mw.CreatePart(m.partHeader(ra))
+		if err != nil {
+			return err
+		}
+		if err = copyRange(part, src, ra); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func (m *MultipartWriter) newWriter(w io.Writer) *multipart.Writer {
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(m.boundary)
+	return mw
+}
+
+func (m *MultipartWriter) partHeader(ra Range) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{"Content-Range": {ra.ContentRange(m.size)}}
+	if m.contentType != "" {
+		h.Set("Content-Type", m.contentType)
+	}
+	return h
+}
+
+// Copy the content of the range from the source to the writer.
+func copyRange(w io.Writer, src Source, ra Range) error {
+	body, err := src.ReadRange(ra.Start, ra.Length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.CopyN(w, body, ra.Length)
+	return err
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,56 @@
+package httprange
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestMultipartWriter(t *testing.T) {
+	const content = "0123456789"
+	var tests = []struct {
+		ranges []Range
+		parts  []string
+		cr     []string
+	}{
+		{[]Range{{0, 2}, {5, 3}}, []string{"01", "567"}, []string{"bytes 0-1/10", "bytes 5-7/10"}},
+		{[]Range{{9, 1}, {0, 1}, {4, 2}}, []string{"9", "0", "45"}, []string{"bytes 9-9/10", "bytes 0-0/10", "bytes 4-5/10"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		mw := NewMultipartWriter(tt.ranges, "video/mp4", int64(len(content)))
+		if err := mw.Write(&buf, ReaderAtSource(strings.NewReader(content))); err != nil {
+			t.Fatalf("Write(%v) returned error %q", tt.ranges, err)
+		}
+		if mw.Length() != int64(buf.Len()) {
+			t.Errorf("Length(%v) = %d, want %d", tt.ranges, mw.Length(), buf.Len())
+		}
+		mt, params, err := mime.ParseMediaType(mw.ContentType())
+		if err != nil || mt != "multipart/byteranges" {
+			t.Fatalf("ContentType() = %q, want multipart/byteranges", mw.ContentType())
+		}
+		r := multipart.NewReader(&buf, params["boundary"])
+		for i := range tt.parts {
+			part, err := r.NextPart()
+			if err != nil {
+				t.Fatalf("NextPart() of %v returned error %q", tt.ranges, err)
+			}
+			if got := part.Header.Get("Content-Range"); got != tt.cr[i] {
+				t.Errorf("part[%d] Content-Range = %q, want %q", i, got, tt.cr[i])
+			}
+			if got := part.Header.Get("Content-Type"); got != "video/mp4" {
+				t.Errorf("part[%d] Content-Type = %q, want %q", i, got, "video/mp4")
+			}
+			body, _ := io.ReadAll(part)
+			if string(body) != tt.parts[i] {
+				t.Errorf("part[%d] body = %q, want %q", i, body, tt.parts[i])
+			}
+		}
+		if _, err := r.NextPart(); err != io.EOF {
+			t.Errorf("expected end of multipart body, got error %v", err)
+		}
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"strings"
 )
 
+// The error is returned if none of the ranges overlaps the file, while other errors of the malformed header are
+// expected to be ignored by serving the entire file.
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+
 type Range struct {
 	Start  int64
 	Length int64
@@ -16,6 +20,19 @@ type ContentRange struct {
 	Start, End, Size int64
 }
 
+// Get the value of Content-Range header for the range of the given file size.
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// Sum the total length of the given ranges.
+func SumLength(ranges []Range) (n int64) {
+	for _, ra := range ranges {
+		n += ra.Length
+	}
+	return n
+}
+
 // Get the length the file resumed to upload.
 func (cr *ContentRange) Length() int64 { return cr.End - cr.Start + 1 }
 
@@ -31,6 +48,9 @@ func (cr *ContentRange) Parts() int64 {
 	return cr.Size/cr.Length() + int64(remainder)
 }
 
+// Determine whether the Content-Range header omits the byte range, e.g. "bytes */1000".
+func (cr *ContentRange) IsUnspecified() bool { return cr.Start < 0 }
+
 // Determine whether the given byte-offset of the last byte in the range.
 func (cr *ContentRange) IsLastByte() bool {
 	return cr.End+1 >= cr.Size
@@ -58,9 +78,13 @@ func ParseRange(s string, size int64) ([]Range, error) {
 		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
 		if start == "" {
 			i, err := strconv.ParseInt(end, 10, 64)
-			if err != nil {
+			if err != nil || i < 0 {
 				return nil, fmt.Errorf("invalid range header %q", s)
 			}
+			// The empty suffix, or any suffix of the empty file, selects no bytes.
+			if i == 0 || size == 0 {
+				return nil, fmt.Errorf("%w: %q", ErrUnsatisfiable, s)
+			}
 			if i > size {
 				i = size
 			}
@@ -68,9 +92,12 @@ func ParseRange(s string, size int64) ([]Range, error) {
 			r.Length = size - r.Start
 		} else {
 			i, err := strconv.ParseInt(start, 10, 64)
-			if err != nil || i >= size || i < 0 {
+			if err != nil || i < 0 {
 				return nil, fmt.Errorf("invalid range header %q", s)
 			}
+			if i >= size {
+				return nil, fmt.Errorf("%w: %q", ErrUnsatisfiable, s)
+			}
 
 			r.Start = i
 			if end == "" {
@@ -94,7 +121,7 @@ func ParseRange(s string, size int64) ([]Range, error) {
 func ParseContentRange(s string) (*ContentRange, error) {
 	const b = "bytes "
 	if s == "" {
-		return nil, nil
+		return nil, errors.New("no Content-Range header")
 	}
 	if !strings.HasPrefix(s, b) {
 		return nil, errors.New("invalid unit of Content-Range header")
@@ -107,6 +134,9 @@ func ParseContentRange(s string) (*ContentRange, error) {
 	if err != nil {
 		return nil, errors.New("cannot parse size of Content-Range header")
 	}
+	if strings.TrimSpace(r[0]) == "*" {
+		return &ContentRange{Start: -1, End: -1, Size: size}, nil
+	}
 	r = strings.Split(r[0], "-")
 	if len(r) != 2 {
 		return nil, errors.New("cannot parse Content-Range header, expected format \"start-end\"")
@@ -1,6 +1,7 @@
 package httprange
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -80,6 +81,7 @@ func TestParseContentRange(t *testing.T) {
 		{"bytes -600/999", &ContentRange{Start: 599, End: 600, Size: 0}, "cannot parse start of Content-Range header"},
 		{"bytes 0-/999", &ContentRange{Start: 599, End: 600, Size: 0}, "cannot parse end of Content-Range header"},
 		{"bytes 0-63/128", &ContentRange{Start: 0, End: 63, Size: 128}, ""},
+		{"bytes */128", &ContentRange{Start: -1, End: -1, Size: 128}, ""},
 	}
 	for _, tt := range tests {
 		cr, err := ParseContentRange(tt.s)
@@ -101,3 +103,25 @@ func TestParseContentRange(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRangeUnsatisfiable(t *testing.T) {
+	var tests = []struct {
+		s             string
+		length        int64
+		unsatisfiable bool
+	}{
+		{"bytes=10-", 10, true},
+		{"bytes=0-5,10-", 10, true},
+		{"bytes=-0", 10, true},
+		{"bytes=-5", 0, true},
+		{"bytes=5-2", 10, false},
+		{"bytes=--5", 10, false},
+		{"items=0-5", 10, false},
+	}
+	for _, tt := range tests {
+		_, err := ParseRange(tt.s, tt.length)
+		if err == nil || errors.Is(err, ErrUnsatisfiable) != tt.unsatisfiable {
+			t.Errorf("ParseRange(%q, %d) returned error %v, want unsatisfiable %t", tt.s, tt.length, err, tt.unsatisfiable)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package persistence
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The writer computes the checksums of the content written to it.
+type checksumWriter struct {
+	md5, sha256 hash.Hash
+}
+
+func newChecksumWriter() *checksumWriter {
+	return &checksumWriter{md5.New(), sha256.New()}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	w.md5.Write(p)
+	return w.sha256.Write(p)
+}
+
+// Verify the checksums of the written content, the empty checksums are skipped.
+func (w *checksumWriter) Verify(expected entity.Checksum) error {
+	if expected.MD5 != "" && expected.MD5 != base64.StdEncoding.EncodeToString(w.md5.Sum(nil)) {
+		return repository.ErrChecksumMismatch
+	}
+	if expected.SHA256 != "" && expected.SHA256 != base64.StdEncoding.EncodeToString(w.sha256.Sum(nil)) {
+		return repository.ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Get the entity tag of the written content.
+func (w *checksumWriter) ETag() string {
+	return hex.EncodeToString(w.md5.Sum(nil))
+}
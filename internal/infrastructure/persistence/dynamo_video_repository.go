@@ -0,0 +1,475 @@
+package persistence
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The global secondary index of the video table, which is partitioned by Status and sorted by Id.
+const dynamoStatusIndex = "Status-Id-index"
+
+// The chunk size of the multipart uploads stored before the parts were keyed by the byte offset.
+const legacyUploadChunkSize = 256 << 10
+
+// The video repository stores videos in the table of AWS DynamoDB.
+type DynamoVideoRepository struct {
+	db        *dynamodb.DynamoDB
+	tableName string
+}
+
+func NewDynamoVideoRepository(sess *session.Session, tableName string) *DynamoVideoRepository {
+	return &DynamoVideoRepository{dynamodb.New(sess), tableName}
+}
+
+// Get the video by the video ID.
+func (r *DynamoVideoRepository) GetById(id string) (*entity.Video, error) {
+	out, err := r.db.GetItem(&dynamodb.GetItemInput{
+		Key:       map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil || len(out.Item) == 0 {
+		return nil, err
+	}
+	return unmarshalVideo(out.Item)
+}
+
+// List the videos matching the filter, starting after the cursor of the previous page.
+// Videos of the given status are queried from the status index, otherwise the table is scanned.
+// The videos stored in the legacy status are only listed by their lifecycle status once they are saved again.
+func (r *DynamoVideoRepository) List(filter repository.VideoFilter, cursor string, limit int) ([]*entity.Video, string, error) {
+	var startKey map[string]*dynamodb.AttributeValue
+	if cursor != "" {
+		if err := decodeCursor(cursor, &startKey); err != nil {
+			return nil, "", err
+		}
+	}
+	names := map[string]*string{"#status": aws.String("Status")}
+	values := map[string]*dynamodb.AttributeValue{}
+	var conditions []string
+	if filter.Status == "" {
+		values[":deleted"] = &dynamodb.AttributeValue{S: aws.String(entity.StatusDeleted)}
+		conditions = append(conditions, "#status <> :deleted")
+	} else {
+		values[":status"] = &dynamodb.AttributeValue{S: aws.String(filter.Status)}
+	}
+	if filter.Tag != "" {
+		names["#tags"] = aws.String("Tags")
+		values[":tag"] = &dynamodb.AttributeValue{S: aws.String(filter.Tag)}
+		conditions = append(conditions, "contains(#tags, :tag)")
+	}
+	var filterExpression *string
+	if len(conditions) > 0 {
+		filterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+	// Items are filtered after they are read, so pages are read until the limit is reached or no items are left.
+	var videos []*entity.Video
+	for len(videos) < limit {
+		var items []map[string]*dynamodb.AttributeValue
+		var err error
+		if filter.Status != "" {
+			var out *dynamodb.QueryOutput
+			out, err = r.db.Query(&dynamodb.QueryInput{
+				ExclusiveStartKey:         startKey,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				FilterExpression:          filterExpression,
+				IndexName:                 aws.String(dynamoStatusIndex),
+				KeyConditionExpression:    aws.String("#status = :status"),
+				Limit:                     aws.Int64(int64(limit - len(videos))),
+				TableName:                 aws.String(r.tableName),
+			})
+			if err == nil {
+				items, startKey = out.Items, out.LastEvaluatedKey
+			}
+		} else {
+			var out *dynamodb.ScanOutput
+			out, err = r.db.Scan(&dynamodb.ScanInput{
+				ExclusiveStartKey:         startKey,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				FilterExpression:          filterExpression,
+				Limit:                     aws.Int64(int64(limit - len(videos))),
+				TableName:                 aws.String(r.tableName),
+			})
+			if err == nil {
+				items, startKey = out.Items, out.LastEvaluatedKey
+			}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		page, err := unmarshalVideos(items)
+		if err != nil {
+			return nil, "", err
+		}
+		videos = append(videos, page...)
+		if len(startKey) == 0 {
+			return videos, "", nil
+		}
+	}
+	next, err := encodeCursor(startKey)
+	return videos, next, err
+}
+
+// Save an entity to the persistence on condition that its version is the stored version, and increase the version.
+func (r *DynamoVideoRepository) Save(video *entity.Video) error {
+	// Videos without version were created or stored before versioning.
+	condition := &dynamodb.PutItemInput{
+		ConditionExpression:      aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]*string{"#version": aws.String("Version")},
+	}
+	if video.Version > 0 {
+		condition.ConditionExpression = aws.String("#version = :version")
+		condition.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.FormatInt(video.Version, 10))},
+		}
+	}
+	video.Version++
+	video.Touch(time.Now())
+	av, err := marshalMap(video)
+	if err != nil {
+		video.Version--
+		return err
+	}
+	_, err = r.db.PutItem(&dynamodb.PutItemInput{
+		ConditionExpression:       condition.ConditionExpression,
+		ExpressionAttributeNames:  condition.ExpressionAttributeNames,
+		ExpressionAttributeValues: condition.ExpressionAttributeValues,
+		Item:                      av,
+		TableName:                 aws.String(r.tableName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		video.Version--
+		return &repository.ConflictError{Id: video.Id, Version: video.Version}
+	}
+	if err != nil {
+		video.Version--
+		log.Printf("failed to save persistence: %v", av)
+	}
+	return err
+}
+
+// Update the title, description, tags and metadata of an existing video.
+func (r *DynamoVideoRepository) Update(video *entity.Video) error {
+	tags, err := marshal(video.Tags)
+	if err != nil {
+		return err
+	}
+	metadata, err := marshal(video.Metadata)
+	if err != nil {
+		return err
+	}
+	out, err := r.updateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":          aws.String("Id"),
+			"#title":       aws.String("Title"),
+			"#description": aws.String("Description"),
+			"#tags":        aws.String("Tags"),
+			"#metadata":    aws.String("Metadata"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":title":       {S: aws.String(video.Title)},
+			":description": {S: aws.String(video.Description)},
+			":tags":        tags,
+			":metadata":    metadata,
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(video.Id)}},
+		ReturnValues:     aws.String(dynamodb.ReturnValueAllNew),
+		UpdateExpression: aws.String("SET #title = :title, #description = :description, #tags = :tags, #metadata = :metadata"),
+	})
+	if err != nil {
+		return notFound(err)
+	}
+	updated, err := unmarshalVideo(out.Attributes)
+	if err != nil {
+		return err
+	}
+	*video = *updated
+	return nil
+}
+
+// Mark the video as deleted, the video is kept in the persistence.
+// Only the status is written on condition that it was not changed, and the transition is appended to the history.
+func (r *DynamoVideoRepository) Delete(id string) error {
+	video, err := r.GetById(id)
+	if err != nil {
+		return err
+	}
+	if video == nil {
+		return repository.ErrVideoNotFound
+	}
+	from, now := video.Status, time.Now()
+	if err = video.Transition(entity.StatusDeleted, now); err != nil {
+		return err
+	}
+	change, err := marshalMap(video.History[len(video.History)-1])
+	if err != nil {
+		return err
+	}
+	// The status read from the legacy status is written on condition that the legacy status was not changed either.
+	_, err = r.updateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#status IN (:from, :legacy)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status":  aws.String("Status"),
+			"#history": aws.String("History"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from":   {S: aws.String(from)},
+			":legacy": {S: aws.String(entity.StatusLegacyProcessed)},
+			":status": {S: aws.String(entity.StatusDeleted)},
+			":change": {L: []*dynamodb.AttributeValue{{M: change}}},
+			":empty":  {L: []*dynamodb.AttributeValue{}},
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		UpdateExpression: aws.String("SET #status = :status, #history = list_append(if_not_exists(#history, :empty), :change)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return &repository.ConflictError{Id: video.Id, Version: video.Version}
+	}
+	return err
+}
+
+// Add a part to the multipart upload of the video and return the updated video.
+// The part is written under its byte offset atomically, so that concurrent uploads never lose parts.
+func (r *DynamoVideoRepository) AddUploadPart(id, uploadId string, part *entity.Part) (*entity.Video, error) {
+	av, err := marshalMap(part)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.updateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#upload.#id = :uploadId"),
+		ExpressionAttributeNames: map[string]*string{
+			"#upload": aws.String("Upload"),
+			"#id":     aws.String("Id"),
+			"#parts":  aws.String("Parts"),
+			"#offset": aws.String(part.Key()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uploadId": {S: aws.String(uploadId)},
+			":part":     {M: av},
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		ReturnValues:     aws.String(dynamodb.ReturnValueAllNew),
+		UpdateExpression: aws.String("SET #upload.#parts.#offset = :part"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalVideo(out.Attributes)
+}
+
+// Save the running digest of the multipart upload of the video and return the updated video.
+// Only the digest is written, so that parts uploaded concurrently are kept.
+func (r *DynamoVideoRepository) SaveUploadDigest(id, uploadId string, digest *entity.Digest) (*entity.Video, error) {
+	av, err := marshalMap(digest)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.updateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#upload.#id = :uploadId"),
+		ExpressionAttributeNames: map[string]*string{
+			"#upload": aws.String("Upload"),
+			"#id":     aws.String("Id"),
+			"#digest": aws.String("Digest"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uploadId": {S: aws.String(uploadId)},
+			":digest":   {M: av},
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		ReturnValues:     aws.String(dynamodb.ReturnValueAllNew),
+		UpdateExpression: aws.String("SET #upload.#digest = :digest"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalVideo(out.Attributes)
+}
+
+// Claim the transcoding of the source file of the video, on condition that the source file has not been claimed,
+// or its claim of the uploaded video has expired without a job.
+// Concurrent claims of the same source file are resolved by the conditional update, only one of them succeeds.
+func (r *DynamoVideoRepository) ClaimTranscode(id, source string, now time.Time) (*entity.Video, error) {
+	av, err := marshalMap(&entity.TranscodeClaim{Source: source, ClaimedAt: now})
+	if err != nil {
+		return nil, err
+	}
+	expired := strconv.FormatInt(now.Add(-entity.TranscodeClaimTimeout).Unix(), 10)
+	out, err := r.updateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("attribute_exists(#id) AND (attribute_not_exists(#claim) OR #claim.#source <> :source OR " +
+			"(#status = :uploaded AND attribute_not_exists(#claim.#jobId) AND #claim.#claimedAt <= :expired))"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":        aws.String("Id"),
+			"#status":    aws.String("Status"),
+			"#claim":     aws.String("TranscodeClaim"),
+			"#source":    aws.String("Source"),
+			"#jobId":     aws.String("JobId"),
+			"#claimedAt": aws.String("ClaimedAt"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":source":   {S: aws.String(source)},
+			":uploaded": {S: aws.String(entity.StatusUploaded)},
+			":expired":  {N: aws.String(expired)},
+			":claim":    {M: av},
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		ReturnValues:     aws.String(dynamodb.ReturnValueAllNew),
+		UpdateExpression: aws.String("SET #claim = :claim"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, repository.ErrTranscodeClaimed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalVideo(out.Attributes)
+}
+
+// Find the videos whose multipart upload in progress has expired at the given time.
+// The videos stored in the legacy status are found as well, which are uploading if they have a multipart upload,
+// and their sessions without deadline are expired by the time they are read.
+func (r *DynamoVideoRepository) FindExpiredUploads(now time.Time) ([]*entity.Video, error) {
+	var videos []*entity.Video
+	var err error
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#status":    aws.String("Status"),
+			"#upload":    aws.String("Upload"),
+			"#expiresAt": aws.String("ExpiresAt"),
+			"#id":        aws.String("Id"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {S: aws.String(entity.StatusUploading)},
+			":legacy": {S: aws.String(entity.StatusLegacyProcessed)},
+			":zero":   unixTime(time.Time{}),
+			":now":    unixTime(now),
+		},
+		FilterExpression: aws.String("(#status IN (:status, :legacy) AND #upload.#expiresAt > :zero AND #upload.#expiresAt < :now) OR " +
+			"(#status = :legacy AND attribute_exists(#upload.#id) AND attribute_not_exists(#upload.#expiresAt))"),
+		TableName: aws.String(r.tableName),
+	}
+	scanErr := r.db.ScanPages(input, func(out *dynamodb.ScanOutput, last bool) bool {
+		var page []*entity.Video
+		if page, err = unmarshalVideos(out.Items); err != nil {
+			return false
+		}
+		videos = append(videos, page...)
+		return true
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return videos, err
+}
+
+// Update the item of the video, the update time and the version of the video are updated along with the given expression.
+func (r *DynamoVideoRepository) updateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	in.ExpressionAttributeNames["#updatedAt"] = aws.String("UpdatedAt")
+	in.ExpressionAttributeNames["#version"] = aws.String("Version")
+	in.ExpressionAttributeValues[":updatedAt"] = unixTime(time.Now())
+	in.ExpressionAttributeValues[":zero"] = &dynamodb.AttributeValue{N: aws.String("0")}
+	in.ExpressionAttributeValues[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	in.UpdateExpression = aws.String(*in.UpdateExpression + ", #updatedAt = :updatedAt, #version = if_not_exists(#version, :zero) + :one")
+	in.TableName = aws.String(r.tableName)
+	return r.db.UpdateItem(in)
+}
+
+// Unmarshal the video from DynamoDB attributes, and map the legacy status of the video to its lifecycle status.
+// The parts of legacy multipart uploads are stored in a list, which are keyed by their byte offsets.
+func unmarshalVideo(item map[string]*dynamodb.AttributeValue) (*entity.Video, error) {
+	item, legacy := legacyParts(item)
+	var video *entity.Video
+	if err := dynamodbattribute.UnmarshalMap(item, &video); err != nil || video == nil {
+		return nil, err
+	}
+	if legacy != nil && video.Upload != nil {
+		var parts []*entity.Part
+		if err := dynamodbattribute.UnmarshalList(legacy, &parts); err != nil {
+			return nil, err
+		}
+		// The parts were numbered by the chunks of the legacy chunk size.
+		video.Upload.ChunkSize = legacyUploadChunkSize
+		for _, part := range parts {
+			part.Offset = (part.PartNumber - 1) * legacyUploadChunkSize
+			part.Size = legacyUploadChunkSize
+			if n := video.Size - part.Offset; n < part.Size {
+				part.Size = n
+			}
+			video.AddUploadPart(part)
+		}
+	}
+	video.MigrateStatus()
+	return video, nil
+}
+
+// Unmarshal the videos from the items of DynamoDB, and map the legacy statuses of the videos to their lifecycle statuses.
+func unmarshalVideos(items []map[string]*dynamodb.AttributeValue) ([]*entity.Video, error) {
+	videos := make([]*entity.Video, 0, len(items))
+	for _, item := range items {
+		video, err := unmarshalVideo(item)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, nil
+}
+
+// Split the legacy list of parts from the attributes of the video, the given attributes are kept as they are.
+func legacyParts(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, []*dynamodb.AttributeValue) {
+	upload, ok := item["Upload"]
+	if !ok || upload.M == nil || upload.M["Parts"] == nil || upload.M["Parts"].L == nil {
+		return item, nil
+	}
+	progress := make(map[string]*dynamodb.AttributeValue, len(upload.M))
+	for k, v := range upload.M {
+		progress[k] = v
+	}
+	delete(progress, "Parts")
+	video := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		video[k] = v
+	}
+	video["Upload"] = &dynamodb.AttributeValue{M: progress}
+	return video, upload.M["Parts"].L
+}
+
+// Marshal the value to DynamoDB attributes, keeping empty maps so that nested attributes can be updated.
+func marshalMap(in interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	return av.M, nil
+}
+
+// Marshal the value to DynamoDB attribute value, keeping empty collections.
+func marshal(in interface{}) (*dynamodb.AttributeValue, error) {
+	return dynamodbattribute.NewEncoder(func(e *dynamodbattribute.Encoder) {
+		e.EnableEmptyCollections = true
+	}).Encode(in)
+}
+
+// Get the attribute value of the time in Unix time.
+func unixTime(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
+
+// Translate the failed condition of the existing video to the error of video not found.
+func notFound(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return repository.ErrVideoNotFound
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+func TestUnmarshalLegacyVideo(t *testing.T) {
+	// The item of a multipart upload in progress stored before the parts were keyed by the byte offset.
+	legacy := map[string]*dynamodb.AttributeValue{
+		"Id":     {S: aws.String("1")},
+		"Size":   {N: aws.String("600000")},
+		"Status": {S: aws.String(entity.StatusLegacyProcessed)},
+		"Upload": {M: map[string]*dynamodb.AttributeValue{
+			"Id":    {S: aws.String("upload")},
+			"First": {N: aws.String("0")},
+			"Last":  {N: aws.String("600000")},
+			"Parts": {L: []*dynamodb.AttributeValue{
+				{M: map[string]*dynamodb.AttributeValue{"ETag": {S: aws.String("a")}, "PartNumber": {N: aws.String("1")}}},
+				{M: map[string]*dynamodb.AttributeValue{"ETag": {S: aws.String("c")}, "PartNumber": {N: aws.String("3")}}},
+				{M: map[string]*dynamodb.AttributeValue{"ETag": {S: aws.String("b")}, "PartNumber": {N: aws.String("2")}}},
+			}},
+		}},
+	}
+	video, err := unmarshalVideo(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != entity.StatusUploading || video.Upload.ChunkSize != legacyUploadChunkSize {
+		t.Errorf("expected legacy upload in status %s, got status %s with chunk size %d", entity.StatusUploading, video.Status, video.Upload.ChunkSize)
+	}
+	expected := map[string]entity.Part{
+		"0":      {ETag: "a", PartNumber: 1, Offset: 0, Size: 262144},
+		"262144": {ETag: "b", PartNumber: 2, Offset: 262144, Size: 262144},
+		"524288": {ETag: "c", PartNumber: 3, Offset: 524288, Size: 75712},
+	}
+	for key, part := range expected {
+		if got := video.Upload.Parts[key]; got == nil || *got != part {
+			t.Errorf("expected part %+v at %s, got %+v", part, key, got)
+		}
+	}
+	if len(video.Upload.Parts) != len(expected) || !video.IsUploaded() {
+		t.Errorf("expected %d parts to be uploaded, got %d parts with %d bytes", len(expected), len(video.Upload.Parts), video.Upload.Received())
+	}
+	// The legacy sessions carry no deadline and are expired once they are read.
+	if !video.IsUploadExpired(time.Now()) {
+		t.Errorf("expected legacy upload to expire, got deadline %v", video.Upload.ExpiresAt)
+	}
+	// The attributes of the item are kept, so that it can be read again.
+	if legacy["Upload"].M["Parts"].L == nil {
+		t.Errorf("expected legacy parts to be kept in the item, got %v", legacy["Upload"])
+	}
+	videos, err := unmarshalVideos([]map[string]*dynamodb.AttributeValue{legacy, {"Id": {S: aws.String("2")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 || len(videos[0].Upload.Parts) != len(expected) || videos[1].Id != "2" {
+		t.Errorf("expected legacy and current videos, got %d videos", len(videos))
+	}
+}
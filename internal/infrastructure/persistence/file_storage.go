@@ -0,0 +1,175 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The storage keeps files on the local filesystem, files are written to objects/ in the root directory,
+// and parts of multipart uploads are kept in uploads/ until the upload is completed.
+type FileStorage struct {
+	dir string
+}
+
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{dir}
+}
+
+// Initiates a multipart upload and return an upload ID from the local filesystem.
+func (s *FileStorage) CreateMultipart(key string) (string, error) {
+	uploadId := uuid.New().String()
+	if err := os.MkdirAll(s.uploadPath(uploadId), 0755); err != nil {
+		return "", err
+	}
+	return uploadId, nil
+}
+
+// Assemble the uploaded parts in order to the file and remove the parts from the local filesystem.
+func (s *FileStorage) CompleteMultipart(key, uploadId string, parts []*entity.Part) error {
+	err := writeFile(s.objectPath(key), func(w io.Writer) error {
+		for _, part := range parts {
+			if err := s.copyPart(w, uploadId, part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(s.uploadPath(uploadId))
+}
+
+// Abort the multipart upload and delete the uploaded parts from the local filesystem.
+func (s *FileStorage) AbortMultipart(key, uploadId string) error {
+	if _, err := os.Stat(s.uploadPath(uploadId)); err != nil {
+		return fmt.Errorf("upload %q does not exist", uploadId)
+	}
+	return os.RemoveAll(s.uploadPath(uploadId))
+}
+
+// Stream an entire file of the given length to the local filesystem, verifying the given checksums if any.
+func (s *FileStorage) SimpleUpload(key string, body io.Reader, length int64, checksum entity.Checksum) error {
+	return writeFile(s.objectPath(key), func(w io.Writer) error {
+		cw := newChecksumWriter()
+		if err := copyLength(io.MultiWriter(w, cw), body, length); err != nil {
+			return err
+		}
+		return cw.Verify(checksum)
+	})
+}
+
+// Stream a file part of the given length to the local filesystem, verifying the given checksums if any.
+func (s *FileStorage) UploadPart(key, uploadId string, body io.Reader, length, partNumber int64, checksum entity.Checksum) (*entity.Part, error) {
+	if _, err := os.Stat(s.uploadPath(uploadId)); err != nil {
+		return nil, fmt.Errorf("upload %q does not exist", uploadId)
+	}
+	cw := newChecksumWriter()
+	err := writeFile(s.partPath(uploadId, partNumber), func(w io.Writer) error {
+		if err := copyLength(io.MultiWriter(w, cw), body, length); err != nil {
+			return err
+		}
+		return cw.Verify(checksum)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entity.Part{ETag: cw.ETag(), PartNumber: partNumber}, nil
+}
+
+// Read a byte range of the file from the local filesystem.
+func (s *FileStorage) Download(key string, start, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.objectPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, start, length), f}, nil
+}
+
+// Get the size of the file on the local filesystem.
+func (s *FileStorage) Size(key string) (int64, error) {
+	fi, err := os.Stat(s.objectPath(key))
+	if os.IsNotExist(err) {
+		return 0, repository.ErrFileNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Copy the content of the uploaded part to the writer, verifying the entity tag of the part.
+func (s *FileStorage) copyPart(w io.Writer, uploadId string, part *entity.Part) error {
+	f, err := os.Open(s.partPath(uploadId, part.PartNumber))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: part %d of upload %q does not exist", repository.ErrInvalidParts, part.PartNumber, uploadId)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cw := newChecksumWriter()
+	if _, err = io.Copy(io.MultiWriter(w, cw), f); err != nil {
+		return err
+	}
+	if etag := cw.ETag(); etag != part.ETag {
+		return fmt.Errorf("%w: entity tag of part %d mismatched, expected %s, got %s", repository.ErrInvalidParts, part.PartNumber, part.ETag, etag)
+	}
+	return nil
+}
+
+func (s *FileStorage) objectPath(key string) string {
+	return filepath.Join(s.dir, "objects", filepath.Clean("/"+key))
+}
+
+func (s *FileStorage) uploadPath(uploadId string) string {
+	return filepath.Join(s.dir, "uploads", filepath.Clean("/"+uploadId))
+}
+
+func (s *FileStorage) partPath(uploadId string, partNumber int64) string {
+	return filepath.Join(s.uploadPath(uploadId), strconv.FormatInt(partNumber, 10))
+}
+
+// Write the file atomically by renaming a temporary file once the content has been written.
+func writeFile(name string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Copy exactly the given length of bytes from the reader to the writer.
+func copyLength(w io.Writer, r io.Reader, length int64) error {
+	n, err := io.Copy(w, io.LimitReader(r, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("unexpected length of body, expected %d bytes, got %d bytes", length, n)
+	}
+	return nil
+}
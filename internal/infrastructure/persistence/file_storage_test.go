@@ -0,0 +1,135 @@
+package persistence
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+func TestFileStorageMultipart(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	uploadId, err := s.CreateMultipart("video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []*entity.Part
+	for i, content := range []string{"hello ", "multipart ", "world"} {
+		part, err := s.UploadPart("video", uploadId, strings.NewReader(content), int64(len(content)), int64(i+1), entity.Checksum{})
+		if err != nil {
+			t.Fatalf("UploadPart(%q) returned error %q", content, err)
+		}
+		parts = append(parts, part)
+	}
+	if _, err = s.UploadPart("video", "unknown", strings.NewReader("foo"), 3, 1, entity.Checksum{}); err == nil {
+		t.Errorf("expected error of unknown upload, got nil")
+	}
+	if err = s.CompleteMultipart("video", uploadId, parts); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start, length int64
+		content       string
+	}{
+		{0, 21, "hello multipart world"},
+		{6, 9, "multipart"},
+		{16, 5, "world"},
+	}
+	for _, tt := range tests {
+		body, err := s.Download("video", tt.start, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := io.ReadAll(body)
+		body.Close()
+		if string(buf) != tt.content {
+			t.Errorf("Download(%d, %d) = %q, want %q", tt.start, tt.length, buf, tt.content)
+		}
+	}
+}
+
+func TestFileStorageMismatchedPart(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	uploadId, err := s.CreateMultipart("video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := s.UploadPart("video", uploadId, strings.NewReader("hello"), 5, 1, entity.Checksum{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.ETag = "b54357faf0632cce46e942fa68356b38"
+	if err = s.CompleteMultipart("video", uploadId, []*entity.Part{part}); err == nil {
+		t.Errorf("expected error of mismatched entity tag, got nil")
+	}
+}
+
+func TestFileStorageSimpleUpload(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	if err := s.SimpleUpload("video", strings.NewReader("hello"), 10, entity.Checksum{}); err == nil {
+		t.Errorf("expected error of unexpected length, got nil")
+	}
+	if err := s.SimpleUpload("video", strings.NewReader("hello"), 5, entity.Checksum{}); err != nil {
+		t.Fatal(err)
+	}
+	body, err := s.Download("video", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if buf, _ := io.ReadAll(body); string(buf) != "ell" {
+		t.Errorf("Download(1, 3) = %q, want %q", buf, "ell")
+	}
+	if size, err := s.Size("video"); err != nil || size != 5 {
+		t.Errorf("Size() = (%d, %v), want (5, nil)", size, err)
+	}
+	if _, err = s.Size("unknown"); !errors.Is(err, repository.ErrFileNotFound) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrFileNotFound, err)
+	}
+}
+
+func TestFileStorageAbortMultipart(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	uploadId, err := s.CreateMultipart("video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := s.UploadPart("video", uploadId, strings.NewReader("hello"), 5, 1, entity.Checksum{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AbortMultipart("video", uploadId); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CompleteMultipart("video", uploadId, []*entity.Part{part}); err == nil {
+		t.Errorf("expected error of aborted upload, got nil")
+	}
+	if err = s.AbortMultipart("video", uploadId); err == nil {
+		t.Errorf("expected error of unknown upload, got nil")
+	}
+}
+
+func TestFileStorageChecksum(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	// MD5 and SHA-256 checksums of "hello" encoded in base64.
+	valid := entity.Checksum{MD5: "XUFAKrxLKna5cZ2REBfFkg==", SHA256: "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}
+	if err := s.SimpleUpload("video", strings.NewReader("hello"), 5, valid); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SimpleUpload("video", strings.NewReader("world"), 5, valid); !errors.Is(err, repository.ErrChecksumMismatch) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrChecksumMismatch, err)
+	}
+	uploadId, err := s.CreateMultipart("video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.UploadPart("video", uploadId, strings.NewReader("world"), 5, 1, entity.Checksum{MD5: valid.MD5}); !errors.Is(err, repository.ErrChecksumMismatch) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrChecksumMismatch, err)
+	}
+	if _, err = s.UploadPart("video", uploadId, strings.NewReader("hello"), 5, 1, valid); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+// The video repository stores each video as a JSON file in the directory on the local filesystem.
+type FileVideoRepository struct {
+	videoStore
+}
+
+func NewFileVideoRepository(dir string) *FileVideoRepository {
+	return &FileVideoRepository{videoStore{backend: fileVideos(dir)}}
+}
+
+// The backend stores each video as a JSON file under the videos directory of the given directory.
+type fileVideos string
+
+func (d fileVideos) read(id string) (*entity.Video, error) {
+	buf, err := os.ReadFile(d.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var video *entity.Video
+	if err = json.Unmarshal(buf, &video); err != nil {
+		return nil, err
+	}
+	video.MigrateStatus()
+	return video, nil
+}
+
+func (d fileVideos) readAll() ([]*entity.Video, error) {
+	names, err := filepath.Glob(filepath.Join(string(d), "videos", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var videos []*entity.Video
+	for _, name := range names {
+		id, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		video, err := d.read(id)
+		if err != nil {
+			return nil, err
+		}
+		if video != nil {
+			videos = append(videos, video)
+		}
+	}
+	return videos, nil
+}
+
+func (d fileVideos) write(video *entity.Video) error {
+	return writeFile(d.path(video.Id), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(video)
+	})
+}
+
+func (d fileVideos) path(id string) string {
+	return filepath.Join(string(d), "videos", url.PathEscape(id)+".json")
+}
@@ -0,0 +1,270 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+func TestFileVideoRepository(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	video, err := r.GetById("1")
+	if err != nil || video != nil {
+		t.Fatalf("GetById() of unknown video = (%v, %v), want (nil, nil)", video, err)
+	}
+	video = entity.NewVideo("1", "title", "description", "video/mp4", 100, []string{"tag"}, map[string]string{"key": "value"})
+	video.NewUpload("upload", time.Time{})
+	if err = r.Save(video); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetById("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != video.Title || got.Size != video.Size || got.Metadata["key"] != "value" || got.Upload.Id != "upload" {
+		t.Errorf("GetById() = %+v, want %+v", got, video)
+	}
+	if got.CreatedAt.IsZero() || !got.UpdatedAt.Equal(got.CreatedAt) || !got.UploadedAt.IsZero() {
+		t.Errorf("expected created video to have created and updated time, got (%v, %v, %v)", got.CreatedAt, got.UpdatedAt, got.UploadedAt)
+	}
+	if err = got.Transition(entity.StatusUploaded, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Save(got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.GetById("1"); err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(video.CreatedAt) || got.UploadedAt.IsZero() || got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("expected uploaded video to keep created time and have uploaded time, got (%v, %v, %v)", got.CreatedAt, got.UpdatedAt, got.UploadedAt)
+	}
+}
+
+func TestFileVideoRepositoryAddUploadPart(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	video := entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+	video.NewUpload("upload", time.Time{})
+	if err := r.Save(video); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddUploadPart("1", "unknown", &entity.Part{}); err == nil {
+		t.Errorf("expected error of unknown upload, got nil")
+	}
+	// Concurrent parts must never be lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			part := &entity.Part{ETag: fmt.Sprint(i), PartNumber: i + 1, Offset: i * 10, Size: 10}
+			if _, err := r.AddUploadPart("1", "upload", part); err != nil {
+				t.Error(err)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	got, err := r.GetById("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Upload.Parts) != 10 || !got.IsUploaded() {
+		t.Errorf("expected 10 parts to be uploaded, got %d parts with %d bytes", len(got.Upload.Parts), got.Upload.Received())
+	}
+}
+
+func TestFileVideoRepositoryFindExpiredUploads(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	now := time.Now()
+	for id, expiresAt := range map[string]time.Time{"1": now.Add(-time.Hour), "2": now.Add(time.Hour), "3": {}} {
+		video := entity.NewVideo(id, "", "", "video/mp4", 100, nil, nil)
+		video.NewUpload("upload", expiresAt)
+		if err := video.Transition(entity.StatusUploading, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Save(video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	videos, err := r.FindExpiredUploads(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 || videos[0].Id != "1" {
+		t.Errorf("expected expired upload of video 1, got %d videos", len(videos))
+	}
+}
+
+func TestFileVideoRepositoryLegacyStatus(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	now := time.Now()
+	// The videos stored before the lifecycle are read in their lifecycle statuses.
+	uploading := entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+	uploading.NewUpload("upload", now.Add(-time.Hour))
+	created := entity.NewVideo("2", "", "", "video/mp4", 100, nil, nil)
+	for _, video := range []*entity.Video{uploading, created} {
+		video.Status = entity.StatusLegacyProcessed
+		if err := r.backend.write(video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := r.GetById("2"); err != nil || got.Status != entity.StatusCreated {
+		t.Errorf("GetById() of legacy video = (%+v, %v), want status %s", got, err, entity.StatusCreated)
+	}
+	videos, err := r.FindExpiredUploads(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 || videos[0].Id != "1" || videos[0].Status != entity.StatusUploading {
+		t.Fatalf("expected expired upload of legacy video 1, got %d videos", len(videos))
+	}
+	if err = videos[0].Transition(entity.StatusFailed, now); err != nil {
+		t.Errorf("expected legacy video to transition to %s, got error (%v)", entity.StatusFailed, err)
+	}
+}
+
+func TestFileVideoRepositoryList(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	for i, status := range []string{entity.StatusUploaded, entity.StatusUploading, entity.StatusUploaded, entity.StatusDeleted, entity.StatusUploaded} {
+		video := entity.NewVideo(fmt.Sprint(i), "", "", "video/mp4", 100, []string{fmt.Sprintf("tag%d", i%2)}, nil)
+		video.Status = status
+		if err := r.Save(video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		filter      repository.VideoFilter
+		limit       int
+		expectedIds [][]string
+	}{
+		{repository.VideoFilter{}, 10, [][]string{{"0", "1", "2", "4"}}},
+		{repository.VideoFilter{}, 2, [][]string{{"0", "1"}, {"2", "4"}}},
+		{repository.VideoFilter{Status: entity.StatusUploaded}, 2, [][]string{{"0", "2"}, {"4"}}},
+		{repository.VideoFilter{Status: entity.StatusDeleted}, 10, [][]string{{"3"}}},
+		{repository.VideoFilter{Tag: "tag1"}, 10, [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		var cursor string
+		for i, expectedIds := range tt.expectedIds {
+			videos, next, err := r.List(tt.filter, cursor, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, video := range videos {
+				ids = append(ids, video.Id)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(expectedIds) {
+				t.Errorf("List(%+v) page %d = %v, want %v", tt.filter, i, ids, expectedIds)
+			}
+			if (next == "") != (i == len(tt.expectedIds)-1) {
+				t.Errorf("List(%+v) page %d returned unexpected cursor %q", tt.filter, i, next)
+			}
+			cursor = next
+		}
+	}
+	if _, _, err := r.List(repository.VideoFilter{}, "foo", 10); !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrInvalidCursor, err)
+	}
+}
+
+func TestFileVideoRepositoryUpdateDelete(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	video := entity.NewVideo("1", "title", "description", "video/mp4", 100, nil, nil)
+	if err := r.Update(video); !errors.Is(err, repository.ErrVideoNotFound) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrVideoNotFound, err)
+	}
+	if err := r.Delete("1"); !errors.Is(err, repository.ErrVideoNotFound) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrVideoNotFound, err)
+	}
+	if err := r.Save(video); err != nil {
+		t.Fatal(err)
+	}
+	update := entity.NewVideo("1", "foo", "bar", "", 0, []string{"baz"}, nil)
+	if err := r.Update(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetById("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "foo" || got.Description != "bar" || got.Tags[0] != "baz" || got.Size != 100 || got.Status != entity.StatusDeleted {
+		t.Errorf("GetById() = %+v, want updated and deleted video", got)
+	}
+}
+
+func TestFileVideoRepositorySaveConflict(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	video := entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)
+	video.NewUpload("upload", time.Time{})
+	if err := r.Save(video); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := r.GetById("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding a part modifies the video, so the video read before is outdated.
+	if _, err = r.AddUploadPart("1", "upload", &entity.Part{PartNumber: 1, Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *repository.ConflictError
+	if err = r.Save(stale); !errors.As(err, &conflict) || conflict.Version != 1 {
+		t.Errorf("expected conflict of version 1, got error (%v)", err)
+	}
+	if err = r.Save(entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)); !errors.As(err, &conflict) {
+		t.Errorf("expected conflict of new video with existing ID, got error (%v)", err)
+	}
+	latest, err := r.GetById("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Save(latest); err != nil || latest.Version != 3 || len(latest.Upload.Parts) != 1 {
+		t.Errorf("expected latest video saved at version 3 with 1 part, got version %d with error (%v)", latest.Version, err)
+	}
+}
+
+func TestFileVideoRepositoryClaimTranscode(t *testing.T) {
+	r := NewFileVideoRepository(t.TempDir())
+	if err := r.Save(entity.NewVideo("1", "", "", "video/mp4", 100, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	// Only one of the concurrent claims of the same source file succeeds.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.ClaimTranscode("1", "abc", time.Now())
+			if err != nil && !errors.Is(err, repository.ErrTranscodeClaimed) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Errorf("expected 1 claim, got %d claims", claimed)
+	}
+	video, err := r.ClaimTranscode("1", "def", time.Now())
+	if err != nil || video.TranscodeClaim.Source != "def" || video.Version != 3 {
+		t.Errorf("expected claim of source def at version 3, got video %+v with error (%v)", video, err)
+	}
+	if _, err = r.ClaimTranscode("2", "abc", time.Now()); !errors.Is(err, repository.ErrVideoNotFound) {
+		t.Errorf("expected error (%v), got error (%v)", repository.ErrVideoNotFound, err)
+	}
+}
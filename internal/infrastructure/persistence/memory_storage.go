@@ -0,0 +1,134 @@
+package persistence
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The storage keeps files in memory, files are lost once the process exits.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int64][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string][]byte{}, uploads: map[string]map[int64][]byte{}}
+}
+
+// Initiates a multipart upload and return an upload ID.
+func (s *MemoryStorage) CreateMultipart(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadId := uuid.New().String()
+	s.uploads[uploadId] = map[int64][]byte{}
+	return uploadId, nil
+}
+
+// Assemble the uploaded parts in order to the file and discard the parts.
+// The parts but the last one must be at least the minimum part size, as they must be in AWS S3.
+func (s *MemoryStorage) CompleteMultipart(key, uploadId string, parts []*entity.Part) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok {
+		return fmt.Errorf("%w: upload %q does not exist", repository.ErrInvalidParts, uploadId)
+	}
+	var buf bytes.Buffer
+	for i, part := range parts {
+		b, ok := upload[part.PartNumber]
+		if !ok || etag(b) != part.ETag {
+			return fmt.Errorf("%w: part %d of upload %q does not exist", repository.ErrInvalidParts, part.PartNumber, uploadId)
+		}
+		if i < len(parts)-1 && len(b) < repository.MinPartSize {
+			return fmt.Errorf("%w: part %d of upload %q", repository.ErrPartTooSmall, part.PartNumber, uploadId)
+		}
+		buf.Write(b)
+	}
+	s.objects[key] = buf.Bytes()
+	delete(s.uploads, uploadId)
+	return nil
+}
+
+// Abort the multipart upload and discard the uploaded parts.
+func (s *MemoryStorage) AbortMultipart(key, uploadId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadId]; !ok {
+		return fmt.Errorf("upload %q does not exist", uploadId)
+	}
+	delete(s.uploads, uploadId)
+	return nil
+}
+
+// Store an entire file of the given length, verifying the given checksums if any.
+func (s *MemoryStorage) SimpleUpload(key string, body io.Reader, length int64, checksum entity.Checksum) error {
+	var buf bytes.Buffer
+	if err := copyVerified(&buf, body, length, checksum); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = buf.Bytes()
+	return nil
+}
+
+// Store a file part of the given length, verifying the given checksums if any.
+func (s *MemoryStorage) UploadPart(key, uploadId string, body io.Reader, length, partNumber int64, checksum entity.Checksum) (*entity.Part, error) {
+	var buf bytes.Buffer
+	if err := copyVerified(&buf, body, length, checksum); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok {
+		return nil, fmt.Errorf("upload %q does not exist", uploadId)
+	}
+	upload[partNumber] = buf.Bytes()
+	return &entity.Part{ETag: etag(buf.Bytes()), PartNumber: partNumber}, nil
+}
+
+// Read a byte range of the file.
+func (s *MemoryStorage) Download(key string, start, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("file %q does not exist", key)
+	}
+	return io.NopCloser(io.NewSectionReader(bytes.NewReader(b), start, length)), nil
+}
+
+// Get the size of the file.
+func (s *MemoryStorage) Size(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[key]
+	if !ok {
+		return 0, repository.ErrFileNotFound
+	}
+	return int64(len(b)), nil
+}
+
+// Get the entity tag of the content.
+func etag(b []byte) string {
+	cw := newChecksumWriter()
+	cw.Write(b)
+	return cw.ETag()
+}
+
+// Copy the given length of bytes to the writer, verifying the given checksums if any.
+func copyVerified(w io.Writer, r io.Reader, length int64, checksum entity.Checksum) error {
+	cw := newChecksumWriter()
+	if err := copyLength(io.MultiWriter(w, cw), r, length); err != nil {
+		return err
+	}
+	return cw.Verify(checksum)
+}
@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"encoding/json"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+// The video repository keeps videos in memory, videos are lost once the process exits.
+type MemoryVideoRepository struct {
+	videoStore
+}
+
+func NewMemoryVideoRepository() *MemoryVideoRepository {
+	return &MemoryVideoRepository{videoStore{backend: memoryVideos{}}}
+}
+
+// The backend keeps videos in memory in encoded form, keyed by the video ID.
+type memoryVideos map[string][]byte
+
+func (m memoryVideos) read(id string) (*entity.Video, error) {
+	buf, ok := m[id]
+	if !ok {
+		return nil, nil
+	}
+	var video *entity.Video
+	err := json.Unmarshal(buf, &video)
+	return video, err
+}
+
+func (m memoryVideos) readAll() ([]*entity.Video, error) {
+	var videos []*entity.Video
+	for id := range m {
+		video, err := m.read(id)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, nil
+}
+
+func (m memoryVideos) write(video *entity.Video) error {
+	buf, err := json.Marshal(video)
+	if err != nil {
+		return err
+	}
+	m[video.Id] = buf
+	return nil
+}
@@ -0,0 +1,143 @@
+package persistence
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The driver opens a storage by the URL.
+type StorageDriver func(u *url.URL) (repository.Storage, error)
+
+// The driver opens a video repository by the URL.
+type VideoRepositoryDriver func(u *url.URL) (repository.VideoRepository, error)
+
+var (
+	driversMu              sync.RWMutex
+	storageDrivers         = map[string]StorageDriver{}
+	videoRepositoryDrivers = map[string]VideoRepositoryDriver{}
+)
+
+func init() {
+	RegisterStorage("s3", openS3Storage)
+	RegisterStorage("file", func(u *url.URL) (repository.Storage, error) {
+		return NewFileStorage(filePath(u)), nil
+	})
+	RegisterStorage("mem", func(u *url.URL) (repository.Storage, error) {
+		return NewMemoryStorage(), nil
+	})
+	RegisterVideoRepository("dynamodb", openDynamoVideoRepository)
+	RegisterVideoRepository("file", func(u *url.URL) (repository.VideoRepository, error) {
+		return NewFileVideoRepository(filePath(u)), nil
+	})
+	RegisterVideoRepository("mem", func(u *url.URL) (repository.VideoRepository, error) {
+		return NewMemoryVideoRepository(), nil
+	})
+}
+
+// Make a storage driver available by the URL scheme.
+func RegisterStorage(scheme string, driver StorageDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	storageDrivers[scheme] = driver
+}
+
+// Make a video repository driver available by the URL scheme.
+func RegisterVideoRepository(scheme string, driver VideoRepositoryDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	videoRepositoryDrivers[scheme] = driver
+}
+
+// Open the storage by the URL, e.g. "s3://bucket?region=us-east-1", "file:///var/lib/molpastream" or "mem://".
+func OpenStorage(rawurl string) (repository.Storage, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage URL %q: %v", rawurl, err)
+	}
+	driversMu.RLock()
+	driver, ok := storageDrivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q", u.Scheme)
+	}
+	return driver(u)
+}
+
+// Open the video repository by the URL, e.g. "dynamodb://table?region=us-east-1", "file:///var/lib/molpastream" or "mem://".
+func OpenVideoRepository(rawurl string) (repository.VideoRepository, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid video repository URL %q: %v", rawurl, err)
+	}
+	driversMu.RLock()
+	driver, ok := videoRepositoryDrivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown video repository driver %q", u.Scheme)
+	}
+	return driver(u)
+}
+
+// The configuration of the storage and metadata backends.
+type Config struct {
+	StorageURL  string // The URL of the storage keeps video files.
+	MetadataURL string // The URL of the video repository keeps video metadata.
+}
+
+// Open the storage and video repository by the configuration.
+func Open(cfg Config) (repository.Storage, repository.VideoRepository, error) {
+	storage, err := OpenStorage(cfg.StorageURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	videos, err := OpenVideoRepository(cfg.MetadataURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storage, videos, nil
+}
+
+func openS3Storage(u *url.URL) (repository.Storage, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("bucket of storage URL %q must be required", u)
+	}
+	sess, err := awsSession(u)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3Storage(sess, u.Host), nil
+}
+
+func openDynamoVideoRepository(u *url.URL) (repository.VideoRepository, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("table of video repository URL %q must be required", u)
+	}
+	sess, err := awsSession(u)
+	if err != nil {
+		return nil, err
+	}
+	return NewDynamoVideoRepository(sess, u.Host), nil
+}
+
+// Create an AWS session by the "region" and "endpoint" query parameters of the URL.
+func awsSession(u *url.URL) (*session.Session, error) {
+	cfg := aws.NewConfig()
+	if region := u.Query().Get("region"); region != "" {
+		cfg.WithRegion(region)
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	return session.NewSession(cfg)
+}
+
+// Get the directory of the file URL, e.g. "file:///var/lib/molpastream" or "file://./data".
+func filePath(u *url.URL) string {
+	return filepath.FromSlash(u.Host + u.Path)
+}
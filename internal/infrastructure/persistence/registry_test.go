@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		cfg         Config
+		expectedErr bool
+	}{
+		{Config{"mem://", "mem://"}, false},
+		{Config{"file://" + filepath.ToSlash(dir), "file://" + filepath.ToSlash(dir)}, false},
+		{Config{"s3://", "mem://"}, true},
+		{Config{"mem://", "dynamodb://"}, true},
+		{Config{"ftp://host", "mem://"}, true},
+		{Config{"mem://", "redis://host"}, true},
+		{Config{"", "mem://"}, true},
+	}
+	for _, tt := range tests {
+		storage, videos, err := Open(tt.cfg)
+		if (err != nil) != tt.expectedErr {
+			t.Errorf("Open(%+v) error = %v, expected error %v", tt.cfg, err, tt.expectedErr)
+		}
+		if err == nil && (storage == nil || videos == nil) {
+			t.Errorf("Open(%+v) returned nil backends", tt.cfg)
+		}
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		path   string
+	}{
+		{"file:///var/lib/molpastream", "/var/lib/molpastream"},
+		{"file://./data", "./data"},
+		{"file://data", "data"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filePath(u); got != filepath.FromSlash(tt.path) {
+			t.Errorf("filePath(%q) = %q, want %q", tt.rawurl, got, tt.path)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package persistence
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The storage keeps files in the bucket of remote AWS S3 storage.
+type S3Storage struct {
+	s3Uploader *s3manager.Uploader
+	bucket     string
+}
+
+func NewS3Storage(sess *session.Session, bucket string) *S3Storage {
+	return &S3Storage{s3manager.NewUploader(sess), bucket}
+}
+
+// Initiates a multipart upload and return an upload ID from remote AWS S3 storage.
+func (s *S3Storage) CreateMultipart(key string) (string, error) {
+	out, err := s.s3Uploader.S3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.UploadId), nil
+}
+
+// Mark the multipart upload as completd for the remote AWS S3 storage.
+// The errors of the parts which S3 never assembles are translated to ErrInvalidParts.
+func (s *S3Storage) CompleteMultipart(key, uploadId string, parts []*entity.Part) error {
+	var fileParts []*s3.CompletedPart
+	for _, part := range parts {
+		fileParts = append(fileParts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.PartNumber),
+		})
+	}
+	_, err := s.s3Uploader.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: fileParts,
+		},
+		UploadId: aws.String(uploadId),
+	})
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "InvalidPart", "InvalidPartOrder", "EntityTooSmall", s3.ErrCodeNoSuchUpload:
+			return fmt.Errorf("%w: %v", repository.ErrInvalidParts, err)
+		}
+	}
+	return err
+}
+
+// Abort the multipart upload and delete the uploaded parts from remote AWS S3 storage.
+func (s *S3Storage) AbortMultipart(key, uploadId string) error {
+	_, err := s.s3Uploader.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	return err
+}
+
+// Stream an entire file of the given length to remote AWS S3 storage.
+// The body is uploaded in parts, only the parts being uploaded are buffered in memory.
+// The file is deleted if the checksums of the content mismatch the given checksums.
+func (s *S3Storage) SimpleUpload(key string, body io.Reader, length int64, checksum entity.Checksum) error {
+	cw := newChecksumWriter()
+	_, err := s.s3Uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   io.TeeReader(body, cw),
+	}, func(u *s3manager.Uploader) {
+		// Grow the part size for large files to stay within the maximum number of parts.
+		if size := length/s3manager.MaxUploadParts + 1; size > u.PartSize {
+			u.PartSize = size
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err = cw.Verify(checksum); err != nil {
+		s.s3Uploader.S3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+		return err
+	}
+	return nil
+}
+
+// Stream a file part of the given length to remote AWS S3 storage.
+// The MD5 checksum is verified by AWS S3, and the SHA-256 checksum is verified while streaming,
+// the part failed to verify is not returned, so that it is replaced once the part is uploaded again.
+func (s *S3Storage) UploadPart(key, uploadId string, body io.Reader, length, partNumber int64, checksum entity.Checksum) (*entity.Part, error) {
+	cw := newChecksumWriter()
+	input := &s3.UploadPartInput{
+		Body:          aws.ReadSeekCloser(io.TeeReader(body, cw)),
+		Bucket:        aws.String(s.bucket),
+		ContentLength: aws.Int64(length),
+		Key:           aws.String(key),
+		PartNumber:    aws.Int64(partNumber),
+		UploadId:      aws.String(uploadId),
+	}
+	if checksum.MD5 != "" {
+		input.ContentMD5 = aws.String(checksum.MD5)
+	}
+	req, out := s.s3Uploader.S3.UploadPartRequest(input)
+	// Sign the request without the payload hash, so that the body is streamed instead of being buffered.
+	req.Handlers.Sign.Remove(v4.SignRequestHandler)
+	req.Handlers.Sign.PushBackNamed(v4.BuildNamedHandler("v4.UnsignedPayloadSignerHandler", v4.WithUnsignedPayload))
+	if err := req.Send(); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "BadDigest" {
+			return nil, repository.ErrChecksumMismatch
+		}
+		return nil, err
+	}
+	if err := cw.Verify(entity.Checksum{SHA256: checksum.SHA256}); err != nil {
+		return nil, err
+	}
+	return &entity.Part{ETag: *out.ETag, PartNumber: partNumber}, nil
+}
+
+// Download a byte range of the file from remote AWS S3 storage.
+func (s *S3Storage) Download(key string, start, length int64) (io.ReadCloser, error) {
+	out, err := s.s3Uploader.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, start+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Get the size of the file in remote AWS S3 storage.
+func (s *S3Storage) Size(key string) (int64, error) {
+	out, err := s.s3Uploader.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+		return 0, repository.ErrFileNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// Get the page of videos matching the filter, videos are ordered by ID and the cursor keeps the last ID of the page.
+func listVideos(videos []*entity.Video, filter repository.VideoFilter, cursor string, limit int) ([]*entity.Video, string, error) {
+	var after string
+	if cursor != "" {
+		if err := decodeCursor(cursor, &after); err != nil {
+			return nil, "", err
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool { return videos[i].Id < videos[j].Id })
+	var page []*entity.Video
+	for _, video := range videos {
+		if video.Id <= after && cursor != "" || !matchVideo(video, filter) {
+			continue
+		}
+		if len(page) == limit {
+			next, err := encodeCursor(page[len(page)-1].Id)
+			return page, next, err
+		}
+		page = append(page, video)
+	}
+	return page, "", nil
+}
+
+// Report whether the video matches the filter, deleted videos only match the filter of deleted status.
+func matchVideo(video *entity.Video, filter repository.VideoFilter) bool {
+	if filter.Status == "" && video.Status == entity.StatusDeleted {
+		return false
+	}
+	if filter.Status != "" && video.Status != filter.Status {
+		return false
+	}
+	if filter.Tag == "" {
+		return true
+	}
+	for _, tag := range video.Tags {
+		if tag == filter.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode the position of listing as an opaque cursor.
+func encodeCursor(v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Decode the cursor to the position of listing.
+func decodeCursor(cursor string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return repository.ErrInvalidCursor
+	}
+	if err = json.Unmarshal(buf, v); err != nil {
+		return repository.ErrInvalidCursor
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The backend keeps encoded videos keyed by the video ID, so that callers never share an entity with the backend.
+type videoBackend interface {
+	// Read the video by the video ID, nil is returned if the video does not exist.
+	read(id string) (*entity.Video, error)
+	// Read all the videos in no particular order.
+	readAll() ([]*entity.Video, error)
+	// Write the video under its ID.
+	write(video *entity.Video) error
+}
+
+// The video repository over a backend without conditional writes, every operation is serialized by the mutex.
+type videoStore struct {
+	mu      sync.Mutex
+	backend videoBackend
+}
+
+// Get the video by the video ID.
+func (s *videoStore) GetById(id string) (*entity.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend.read(id)
+}
+
+// List the videos matching the filter, starting after the cursor of the previous page.
+func (s *videoStore) List(filter repository.VideoFilter, cursor string, limit int) ([]*entity.Video, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos, err := s.backend.readAll()
+	if err != nil {
+		return nil, "", err
+	}
+	return listVideos(videos, filter, cursor, limit)
+}
+
+// Save an entity to the persistence on condition that its version is the stored version, and increase the version.
+func (s *videoStore) Save(video *entity.Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.backend.read(video.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil && video.Version != 0 || current != nil && current.Version != video.Version {
+		return &repository.ConflictError{Id: video.Id, Version: video.Version}
+	}
+	video.Version++
+	video.Touch(time.Now())
+	return s.backend.write(video)
+}
+
+// Update the title, description, tags and metadata of an existing video.
+func (s *videoStore) Update(video *entity.Video) error {
+	updated, err := s.modify(video.Id, func(current *entity.Video) error {
+		current.Title, current.Description, current.Tags, current.Metadata = video.Title, video.Description, video.Tags, video.Metadata
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*video = *updated
+	return nil
+}
+
+// Mark the video as deleted, the video is kept in the persistence.
+func (s *videoStore) Delete(id string) error {
+	_, err := s.modify(id, func(video *entity.Video) error {
+		return video.Transition(entity.StatusDeleted, time.Now())
+	})
+	return err
+}
+
+// Add a part to the multipart upload of the video and return the updated video.
+func (s *videoStore) AddUploadPart(id, uploadId string, part *entity.Part) (*entity.Video, error) {
+	return s.modify(id, func(video *entity.Video) error {
+		if video.Upload == nil || video.Upload.Id != uploadId {
+			return fmt.Errorf("upload %q of video %q does not exist", uploadId, id)
+		}
+		video.AddUploadPart(part)
+		return nil
+	})
+}
+
+// Save the running digest of the multipart upload of the video and return the updated video.
+func (s *videoStore) SaveUploadDigest(id, uploadId string, digest *entity.Digest) (*entity.Video, error) {
+	return s.modify(id, func(video *entity.Video) error {
+		if video.Upload == nil || video.Upload.Id != uploadId {
+			return fmt.Errorf("upload %q of video %q does not exist", uploadId, id)
+		}
+		video.Upload.Digest = digest
+		return nil
+	})
+}
+
+// Claim the transcoding of the source file of the video, on condition that the source file has not been claimed,
+// or its claim of the uploaded video has expired without a job.
+func (s *videoStore) ClaimTranscode(id, source string, now time.Time) (*entity.Video, error) {
+	return s.modify(id, func(video *entity.Video) error {
+		if !video.ClaimTranscode(source, now) {
+			return repository.ErrTranscodeClaimed
+		}
+		return nil
+	})
+}
+
+// Find the videos whose multipart upload in progress has expired at the given time.
+func (s *videoStore) FindExpiredUploads(now time.Time) ([]*entity.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos, err := s.backend.readAll()
+	if err != nil {
+		return nil, err
+	}
+	var expired []*entity.Video
+	for _, video := range videos {
+		if video.IsUploadExpired(now) {
+			expired = append(expired, video)
+		}
+	}
+	return expired, nil
+}
+
+// Apply the change to the existing video, and write the video with the increased version unless the change fails.
+func (s *videoStore) modify(id string, change func(video *entity.Video) error) (*entity.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, err := s.backend.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if video == nil {
+		return nil, repository.ErrVideoNotFound
+	}
+	if err = change(video); err != nil {
+		return nil, err
+	}
+	video.Version++
+	video.Touch(time.Now())
+	if err = s.backend.write(video); err != nil {
+		return nil, err
+	}
+	return video, nil
+}
@@ -0,0 +1,262 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+const (
+	// The bitrate of the audio rendition, which is the bitrate of the audio output of job.json.
+	audioBitrate = 96000
+	// The duration of segments in seconds, which is the segment length of job.json.
+	segmentLength = 6
+	// The maximum bytes of the standard error of the encoder kept as the error message of the failed job.
+	maxErrorMessageSize = 1024
+	// The result of the job is reported again if the video was written concurrently,
+	// or the video has not been marked as transcoding by the job yet.
+	maxReportAttempts   = 5
+	reportRetryInterval = time.Second
+)
+
+// The error is returned if the queue of the transcoder is full, the video can be transcoded again later.
+var ErrQueueFull = errors.New("queue of transcoding jobs is full")
+
+// The job queued to the transcoder.
+type ffmpegJob struct {
+	id       string
+	videoId  string
+	ladder   []*entity.Rendition
+	queuedAt time.Time
+}
+
+// The transcoder runs the encoder binary on the local machine to transcode videos to HLS streams, e.g. in development
+// and tests. The jobs are queued in process and run one at a time by the worker.
+type FFmpegTranscoder struct {
+	videos  repository.VideoRepository
+	sources repository.Downloader
+	streams repository.Uploader
+	encoder string // The path of the encoder binary, which accepts the command line options of ffmpeg.
+	queue   chan *ffmpegJob
+}
+
+// Create the transcoder reading source files from the sources and writing the streams to the streams,
+// the jobs are rejected once the given number of jobs are queued.
+func NewFFmpegTranscoder(videos repository.VideoRepository, sources repository.Downloader, streams repository.Uploader, encoder string, queueSize int) *FFmpegTranscoder {
+	return &FFmpegTranscoder{videos, sources, streams, encoder, make(chan *ffmpegJob, queueSize)}
+}
+
+// Queue the job transcoding the source file of the video to the HLS stream.
+func (t *FFmpegTranscoder) Transcode(video *entity.Video) (*entity.TranscodeJob, error) {
+	ladder := videoLadder(video, t.sources)
+	job := &entity.TranscodeJob{Id: uuid.New().String(), HLS: hlsStream(video.Id, ladder)}
+	select {
+	case t.queue <- &ffmpegJob{job.Id, video.Id, ladder, time.Now()}:
+		return job, nil
+	default:
+		return nil, ErrQueueFull
+	}
+}
+
+// Run the queued jobs one at a time until the context is done, the encoder is killed once the context is done.
+func (t *FFmpegTranscoder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-t.queue:
+			t.run(ctx, j)
+		}
+	}
+}
+
+// Run the job and mark the video as ready with the outputs of the job, or failed with the error of the encoder.
+func (t *FFmpegTranscoder) run(ctx context.Context, j *ffmpegJob) {
+	outputs, err := t.encode(ctx, j)
+	now := time.Now()
+	finish := func(video *entity.Video) error {
+		if err != nil {
+			// The exit code of the encoder is reported as the error code.
+			var code int64
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = int64(exitErr.ExitCode())
+			}
+			return video.FailTranscode(j.id, code, err.Error(), now)
+		}
+		return video.CompleteTranscode(j.id, outputs, now)
+	}
+	if rerr := t.report(j, finish); rerr != nil {
+		log.Printf("failed to report transcoding job %s of video %s: %v", j.id, j.videoId, rerr)
+		return
+	}
+	log.Printf("transcoding job %s of video %s has finished, error: %v", j.id, j.videoId, err)
+}
+
+// Apply the result of the job to the latest video and save it.
+func (t *FFmpegTranscoder) report(j *ffmpegJob, finish func(*entity.Video) error) error {
+	for attempt := 1; ; attempt++ {
+		video, err := t.videos.GetById(j.videoId)
+		if err != nil {
+			return err
+		}
+		if video == nil {
+			return repository.ErrVideoNotFound
+		}
+		err = finish(video)
+		// The video is marked as transcoding by the job once the launch of the job is saved,
+		// the video is still transcoded by the job launched before until then.
+		pending := video.Transcode == nil || video.Transcode.StartedAt.Before(j.queuedAt)
+		if errors.Is(err, entity.ErrUnknownTranscodeJob) && pending && attempt < maxReportAttempts {
+			time.Sleep(reportRetryInterval)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = t.videos.Save(video)
+		var conflict *repository.ConflictError
+		if !errors.As(err, &conflict) || attempt == maxReportAttempts {
+			return err
+		}
+	}
+}
+
+// Transcode the source file of the video in a temporary directory, and upload the files of the stream.
+// The names of the files produced by the encoder are returned as the outputs.
+func (t *FFmpegTranscoder) encode(ctx context.Context, j *ffmpegJob) ([]string, error) {
+	dir, err := os.MkdirTemp("", "molpastream-transcode-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	src, out := filepath.Join(dir, "source"), filepath.Join(dir, "out")
+	if err = t.download(j.videoId, src); err != nil {
+		return nil, err
+	}
+	if err = os.Mkdir(out, 0755); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.encoder, encoderArgs(src, j.videoId, j.ladder)...)
+	cmd.Dir, cmd.Stderr = out, &stderr
+	if err = cmd.Run(); err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if len(msg) > maxErrorMessageSize {
+			msg = msg[len(msg)-maxErrorMessageSize:]
+		}
+		return nil, fmt.Errorf("encoder failed: %w: %s", err, msg)
+	}
+	if _, err = os.Stat(filepath.Join(out, j.videoId+".m3u8")); err != nil {
+		return nil, fmt.Errorf("encoder did not produce the master playlist: %v", err)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err = t.upload(filepath.Join(out, e.Name()), e.Name()); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, e.Name())
+	}
+	return outputs, nil
+}
+
+// Download the source file of the video to the local file.
+func (t *FFmpegTranscoder) download(key, name string) error {
+	size, err := t.sources.Size(key)
+	if err != nil {
+		return err
+	}
+	body, err := t.sources.Download(key, 0, size)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Upload the local file to the storage of the streams.
+func (t *FFmpegTranscoder) upload(name, key string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return t.streams.SimpleUpload(key, f, fi.Size(), entity.Checksum{})
+}
+
+// Get the HLS stream produced by the encoder, the playlists are named in the same way as MediaConvert,
+// e.g. 1.m3u8, 1_360.m3u8 and 1_audio.m3u8.
+func hlsStream(id string, ladder []*entity.Rendition) *entity.Stream {
+	stream := &entity.Stream{Playlist: id + ".m3u8"}
+	for _, r := range ladder {
+		rendition := *r
+		rendition.Playlist = id + nameModifier(r) + ".m3u8"
+		stream.Renditions = append(stream.Renditions, &rendition)
+	}
+	audio := &entity.Rendition{Bitrate: audioBitrate, Codec: "AAC", Playlist: id + "_audio.m3u8"}
+	stream.Renditions = append(stream.Renditions, audio)
+	return stream
+}
+
+// Get the command line options of ffmpeg transcoding the source file to the renditions of the ladder,
+// which produces the HLS stream of the same renditions as the HLS output group of job.json in the working directory.
+// The video renditions share the audio rendition, and the source file is expected to have an audio track.
+func encoderArgs(src, id string, ladder []*entity.Rendition) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src}
+	split := fmt.Sprintf("[0:v]split=%d", len(ladder))
+	var filters, streamMap []string
+	for i, r := range ladder {
+		split += fmt.Sprintf("[s%d]", i)
+		filters = append(filters, fmt.Sprintf("[s%d]scale=%d:%d[v%d]", i, r.Width, r.Height, i))
+	}
+	args = append(args, "-filter_complex", strings.Join(append([]string{split}, filters...), ";"))
+	// The bitrate of renditions is capped by the maximum bitrate, which is similar to QVBR rate control of job.json.
+	args = append(args, "-c:v", "libx264", "-profile:v", "main", "-crf", "23", "-force_key_frames:v", "expr:gte(t,n_forced*2)")
+	for i, r := range ladder {
+		bitrate := strconv.FormatInt(r.Bitrate, 10)
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-maxrate:v:%d", i), bitrate, fmt.Sprintf("-bufsize:v:%d", i), bitrate)
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,agroup:audio,name:%s", i, strings.TrimPrefix(nameModifier(r), "_")))
+	}
+	streamMap = append(streamMap, "a:0,agroup:audio,name:audio")
+	return append(args,
+		"-map", "0:a:0", "-c:a", "aac", "-b:a", strconv.Itoa(audioBitrate), "-ac", "2", "-ar", "48000",
+		"-f", "hls", "-hls_time", strconv.Itoa(segmentLength), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", id+"_%v_%05d.ts",
+		"-master_pl_name", id+".m3u8",
+		"-var_stream_map", strings.Join(streamMap, " "),
+		id+"_%v.m3u8",
+	)
+}
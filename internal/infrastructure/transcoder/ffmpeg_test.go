@@ -0,0 +1,105 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/infrastructure/persistence"
+)
+
+// The stub of ffmpeg writes the master playlist and the playlists named by the output pattern.
+const stubEncoder = `#!/bin/sh
+while [ $# -gt 1 ]; do
+	case "$1" in
+	-i) cp "$2" source.copy ;;
+	-master_pl_name) master="$2" ;;
+	esac
+	shift
+done
+echo "#EXTM3U" > "$master"
+for name in 360 540 audio; do
+	echo "#EXTM3U" > "$(echo "$1" | sed "s/%v/$name/")"
+done
+`
+
+// The stub of ffmpeg fails with the error message.
+const failingEncoder = `#!/bin/sh
+echo "Stream map 'a:0' matches no streams." >&2
+exit 3
+`
+
+// Write the script of the stub encoder and return its path.
+func writeEncoder(t *testing.T, script string) string {
+	name := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(name, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestFFmpegTranscoder(t *testing.T) {
+	tests := []struct {
+		encoder         string
+		expectedStatus  string
+		expectedOutputs []string
+		expectedCode    int64
+	}{
+		{stubEncoder, entity.StatusReady, []string{"1.m3u8", "1_360.m3u8", "1_540.m3u8", "1_audio.m3u8", "source.copy"}, 0},
+		{failingEncoder, entity.StatusFailed, nil, 3},
+	}
+	for _, tt := range tests {
+		videos, sources, streams := persistence.NewMemoryVideoRepository(), persistence.NewMemoryStorage(), persistence.NewMemoryStorage()
+		if err := sources.SimpleUpload("1", strings.NewReader("hello"), 5, entity.Checksum{}); err != nil {
+			t.Fatal(err)
+		}
+		video := entity.NewVideo("1", "", "", "video/mp4", 5, nil, nil)
+		video.Profile = "mobile"
+		if err := video.Transition(entity.StatusUploaded, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		tr := NewFFmpegTranscoder(videos, sources, streams, writeEncoder(t, tt.encoder), 1)
+		job, err := tr.Transcode(video)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The job of the unknown resolution produces the entire ladder of the profile and the audio rendition.
+		if job.HLS.Playlist != "1.m3u8" || len(job.HLS.Renditions) != 3 || job.HLS.Renditions[1].Playlist != "1_540.m3u8" {
+			t.Errorf("expected HLS stream 1.m3u8 with 3 renditions, got %+v", job.HLS)
+		}
+		if _, err = tr.Transcode(video); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected error (%v), got error (%v)", ErrQueueFull, err)
+		}
+		if err = video.StartTranscode(job, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err = videos.Save(video); err != nil {
+			t.Fatal(err)
+		}
+		tr.run(context.Background(), <-tr.queue)
+		got, err := videos.GetById("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tt.expectedStatus || got.Transcode.ErrorCode != tt.expectedCode {
+			t.Errorf("expected status (%s) and error code (%d), got status (%s) and error code (%d)", tt.expectedStatus, tt.expectedCode, got.Status, got.Transcode.ErrorCode)
+		}
+		if strings.Join(got.Transcode.Outputs, ",") != strings.Join(tt.expectedOutputs, ",") {
+			t.Errorf("expected outputs %v, got outputs %v", tt.expectedOutputs, got.Transcode.Outputs)
+		}
+		// The files of the stream are uploaded to the storage of the streams.
+		for _, key := range tt.expectedOutputs {
+			if _, err = streams.Size(key); err != nil {
+				t.Errorf("expected file %s in the storage of streams, got error (%v)", key, err)
+			}
+		}
+		if tt.expectedCode != 0 && !strings.Contains(got.Transcode.ErrorMessage, "matches no streams") {
+			t.Errorf("expected error message of the encoder, got (%s)", got.Transcode.ErrorMessage)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/mediaconvert"
+	"github.com/aws/aws-sdk-go/service/mediaconvert/mediaconvertiface"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+)
+
+// The configuration of the MediaConvert jobs.
+type MediaConvertConfig struct {
+	Role         string // The ARN of the IAM role the jobs run as.
+	Settings     []byte // The job settings in JSON, the template of the settings of every job.
+	SourceBucket string // The bucket keeps the source files of videos keyed by the video ID.
+	StreamBucket string // The bucket keeps the HLS and DASH streams, the files of each stream are distinguished by extensions.
+}
+
+// The transcoder launches AWS MediaConvert jobs, the results of the jobs are reported by the job state change events.
+type MediaConvertTranscoder struct {
+	client  mediaconvertiface.MediaConvertAPI
+	sources repository.Downloader
+	cfg     MediaConvertConfig
+}
+
+func NewMediaConvertTranscoder(client mediaconvertiface.MediaConvertAPI, sources repository.Downloader, cfg MediaConvertConfig) *MediaConvertTranscoder {
+	return &MediaConvertTranscoder{client, sources, cfg}
+}
+
+// Launch the MediaConvert job transcoding the source file of the video to the HLS and DASH streams.
+func (t *MediaConvertTranscoder) Transcode(video *entity.Video) (*entity.TranscodeJob, error) {
+	js, job, err := t.jobSettings(video.Id, videoLadder(video, t.sources))
+	if err != nil {
+		return nil, err
+	}
+	// The video ID is carried by the job, so that the job state change events can be traced back to the video.
+	out, err := t.client.CreateJob(&mediaconvert.CreateJobInput{
+		Role:         aws.String(t.cfg.Role),
+		Settings:     js,
+		UserMetadata: map[string]*string{"videoId": aws.String(video.Id)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("mediaconvert job launched %v", out)
+	job.Id = aws.StringValue(out.Job.Id)
+	return job, nil
+}
+
+// Get the settings of the job transcoding the video to the renditions of the ladder, and the job producing the streams.
+func (t *MediaConvertTranscoder) jobSettings(id string, ladder []*entity.Rendition) (*mediaconvert.JobSettings, *entity.TranscodeJob, error) {
+	var js *mediaconvert.JobSettings
+	if err := json.Unmarshal(t.cfg.Settings, &js); err != nil {
+		return nil, nil, fmt.Errorf("invalid job settings: %v", err)
+	}
+	if js == nil || len(js.Inputs) == 0 {
+		return nil, nil, fmt.Errorf("job settings must have an input")
+	}
+	js.Inputs[0].FileInput = aws.String(s3Path(t.cfg.SourceBucket, id))
+	job := &entity.TranscodeJob{}
+	for _, group := range js.OutputGroups {
+		if err := ladderOutputs(group, ladder); err != nil {
+			return nil, nil, err
+		}
+		settings := group.OutputGroupSettings
+		switch aws.StringValue(settings.Type) {
+		case mediaconvert.OutputGroupTypeHlsGroupSettings:
+			settings.HlsGroupSettings.Destination = aws.String(s3Path(t.cfg.StreamBucket, id))
+			job.HLS = outputStream(group, t.cfg.StreamBucket, id)
+		case mediaconvert.OutputGroupTypeDashIsoGroupSettings:
+			settings.DashIsoGroupSettings.Destination = aws.String(s3Path(t.cfg.StreamBucket, id))
+			job.DASH = outputStream(group, t.cfg.StreamBucket, id)
+		}
+	}
+	return js, job, nil
+}
+
+// Get URI path for a file stored in S3 bucket.
+func s3Path(bucket, key string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
+
+// Get the stream produced by the HLS or DASH output group, the manifests are named after the key of the destination.
+func outputStream(group *mediaconvert.OutputGroup, bucket, key string) *entity.Stream {
+	hls := aws.StringValue(group.OutputGroupSettings.Type) == mediaconvert.OutputGroupTypeHlsGroupSettings
+	stream := &entity.Stream{Bucket: bucket, Playlist: key + ".mpd"}
+	if hls {
+		stream.Playlist = key + ".m3u8"
+	}
+	for _, output := range group.Outputs {
+		rendition := &entity.Rendition{}
+		// Only HLS streams have the media playlists of renditions.
+		if hls {
+			rendition.Playlist = key + aws.StringValue(output.NameModifier) + ".m3u8"
+		}
+		if vd := output.VideoDescription; vd != nil && vd.CodecSettings != nil {
+			rendition.Width, rendition.Height = aws.Int64Value(vd.Width), aws.Int64Value(vd.Height)
+			rendition.Codec = aws.StringValue(vd.CodecSettings.Codec)
+			if h264 := vd.CodecSettings.H264Settings; h264 != nil {
+				// The maximum bitrate is given by QVBR and VBR rate control, and the bitrate by CBR.
+				rendition.Bitrate = aws.Int64Value(h264.MaxBitrate)
+				if rendition.Bitrate == 0 {
+					rendition.Bitrate = aws.Int64Value(h264.Bitrate)
+				}
+			}
+		} else if len(output.AudioDescriptions) > 0 && output.AudioDescriptions[0].CodecSettings != nil {
+			cs := output.AudioDescriptions[0].CodecSettings
+			rendition.Codec = aws.StringValue(cs.Codec)
+			if cs.AacSettings != nil {
+				rendition.Bitrate = aws.Int64Value(cs.AacSettings.Bitrate)
+			}
+		}
+		stream.Renditions = append(stream.Renditions, rendition)
+	}
+	return stream
+}
+
+// Replace the video outputs of the output group with the outputs of the renditions in the ladder.
+// The first video output is the template of the outputs, and the outputs without video are kept as is.
+func ladderOutputs(group *mediaconvert.OutputGroup, ladder []*entity.Rendition) error {
+	var template *mediaconvert.Output
+	var outputs []*mediaconvert.Output
+	for _, output := range group.Outputs {
+		if output.VideoDescription == nil {
+			outputs = append(outputs, output)
+		} else if template == nil {
+			template = output
+		}
+	}
+	if template == nil {
+		return nil
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	var videoOutputs []*mediaconvert.Output
+	for _, r := range ladder {
+		var output *mediaconvert.Output
+		if err = json.Unmarshal(buf, &output); err != nil {
+			return err
+		}
+		output.NameModifier = aws.String(nameModifier(r))
+		vd := output.VideoDescription
+		vd.Width, vd.Height = aws.Int64(r.Width), aws.Int64(r.Height)
+		if h264 := vd.CodecSettings.H264Settings; h264 != nil {
+			// CBR rate control is given by the bitrate, QVBR and VBR rate control by the maximum bitrate.
+			if h264.Bitrate != nil {
+				h264.Bitrate = aws.Int64(r.Bitrate)
+			} else {
+				h264.MaxBitrate = aws.Int64(r.Bitrate)
+			}
+		}
+		videoOutputs = append(videoOutputs, output)
+	}
+	group.Outputs = append(videoOutputs, outputs...)
+	return nil
+}
@@ -0,0 +1,48 @@
+package transcoder
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molpadia/molpastream/internal/domain/entity"
+)
+
+// The job settings of an HLS output group, which has a video output and an audio output.
+const testJobSettings = `{
+	"Inputs": [{"FileInput": "s3://EXAMPLE-INPUT-BUCKET/input.mp4"}],
+	"OutputGroups": [{
+		"OutputGroupSettings": {"Type": "HLS_GROUP_SETTINGS", "HlsGroupSettings": {"SegmentLength": 6}},
+		"Outputs": [
+			{"VideoDescription": {"Width": 640, "Height": 360, "CodecSettings": {"Codec": "H_264", "H264Settings": {"MaxBitrate": 1200000, "RateControlMode": "QVBR"}}}, "NameModifier": "_360"},
+			{"AudioDescriptions": [{"CodecSettings": {"Codec": "AAC", "AacSettings": {"Bitrate": 96000}}}], "NameModifier": "_audio"}
+		]
+	}]
+}`
+
+func TestMediaConvertJobSettings(t *testing.T) {
+	tr := NewMediaConvertTranscoder(nil, nil, MediaConvertConfig{Settings: []byte(testJobSettings), SourceBucket: "input", StreamBucket: "hls"})
+	profile, _ := entity.GetProfile("premium-4k")
+	// The ladder of the portrait source in 1080p.
+	js, job, err := tr.jobSettings("1", profile.Ladder(1080, 1920))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(js.Inputs[0].FileInput) != "s3://input/1" || aws.StringValue(js.OutputGroups[0].OutputGroupSettings.HlsGroupSettings.Destination) != "s3://hls/1" {
+		t.Errorf("expected input s3://input/1 and destination s3://hls/1, got settings %v", js)
+	}
+	expected := []entity.Rendition{
+		{Width: 360, Height: 640, Bitrate: 1200000, Codec: "H_264", Playlist: "1_360.m3u8"},
+		{Width: 540, Height: 960, Bitrate: 3500000, Codec: "H_264", Playlist: "1_540.m3u8"},
+		{Width: 720, Height: 1280, Bitrate: 5000000, Codec: "H_264", Playlist: "1_720.m3u8"},
+		{Width: 1080, Height: 1920, Bitrate: 8000000, Codec: "H_264", Playlist: "1_1080.m3u8"},
+		{Bitrate: 96000, Codec: "AAC", Playlist: "1_audio.m3u8"},
+	}
+	if job.HLS == nil || job.HLS.Playlist != "1.m3u8" || len(job.HLS.Renditions) != len(expected) {
+		t.Fatalf("expected HLS stream 1.m3u8 with %d renditions, got %+v", len(expected), job.HLS)
+	}
+	for i, r := range job.HLS.Renditions {
+		if *r != expected[i] {
+			t.Errorf("expected rendition %+v, got rendition %+v", expected[i], *r)
+		}
+	}
+}
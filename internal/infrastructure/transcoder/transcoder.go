@@ -0,0 +1,48 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/molpadia/molpastream/internal/domain/entity"
+	"github.com/molpadia/molpastream/internal/domain/repository"
+	"github.com/molpadia/molpastream/internal/httprange"
+	"github.com/molpadia/molpastream/internal/mediainfo"
+)
+
+// Get the renditions of the transcode profile of the video, which never exceed the resolution of the source file.
+func videoLadder(video *entity.Video, sources repository.Downloader) []*entity.Rendition {
+	profile, ok := entity.GetProfile(video.Profile)
+	if !ok {
+		log.Printf("video %s has unknown transcode profile %s, %s profile is used", video.Id, video.Profile, entity.DefaultProfile)
+		profile, _ = entity.GetProfile(entity.DefaultProfile)
+	}
+	return profile.Ladder(sourceResolution(sources, video.Id))
+}
+
+// Get the resolution of the source video, zero is returned if the resolution cannot be probed,
+// e.g. the source is not an MP4 or QuickTime file.
+func sourceResolution(sources repository.Downloader, key string) (width, height int64) {
+	size, err := sources.Size(key)
+	if err == nil {
+		src := httprange.SourceFunc(func(start, length int64) (io.ReadCloser, error) {
+			return sources.Download(key, start, length)
+		})
+		width, height, err = mediainfo.Resolution(src, size)
+	}
+	if err != nil {
+		log.Printf("failed to probe resolution of video %s: %v", key, err)
+		return 0, 0
+	}
+	return width, height
+}
+
+// Get the suffix of the files of the rendition, which is named after its short side, e.g. _360 and _1080.
+func nameModifier(r *entity.Rendition) string {
+	short := r.Height
+	if r.Width < short {
+		short = r.Width
+	}
+	return fmt.Sprintf("_%d", short)
+}
@@ -0,0 +1,169 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/molpadia/molpastream/internal/httprange"
+)
+
+// The movie box is read into memory, so the box beyond the size is not probed.
+const maxMovieBoxSize = 64 << 20
+
+var (
+	// The error is returned if the file has no video track of a known resolution.
+	ErrUnknownResolution = errors.New("unknown resolution of video")
+	// The error is returned if the boxes of the file are malformed.
+	ErrInvalidBox = errors.New("invalid box of MP4 file")
+)
+
+// Get the display resolution of the video track of the MP4 or QuickTime file of the given size.
+// Only the top-level box headers and the movie box are read from the source, the media data is never read.
+func Resolution(src httprange.Source, size int64) (width, height int64, err error) {
+	for offset := int64(0); offset+8 <= size; {
+		header, err := readRange(src, offset, min64(16, size-offset))
+		if err != nil {
+			return 0, 0, err
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			// The box extends to the end of the file.
+			boxSize = size - offset
+		case 1:
+			if len(header) < 16 {
+				return 0, 0, ErrInvalidBox
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || boxSize > size-offset {
+			return 0, 0, ErrInvalidBox
+		}
+		if string(header[4:8]) == "moov" {
+			if boxSize-headerSize > maxMovieBoxSize {
+				return 0, 0, fmt.Errorf("movie box exceeds %d bytes", maxMovieBoxSize)
+			}
+			moov, err := readRange(src, offset+headerSize, boxSize-headerSize)
+			if err != nil {
+				return 0, 0, err
+			}
+			return movieResolution(moov)
+		}
+		offset += boxSize
+	}
+	return 0, 0, ErrUnknownResolution
+}
+
+// Get the resolution of the video track in the movie box, the largest track is taken if no track is
+// declared as video by its handler.
+func movieResolution(moov []byte) (width, height int64, err error) {
+	var found, video bool
+	err = walkBoxes(moov, func(typ string, body []byte) error {
+		if typ != "trak" {
+			return nil
+		}
+		w, h, v, err := trackResolution(body)
+		if err != nil || w == 0 || h == 0 || video && !v {
+			return err
+		}
+		if v && !video || w*h > width*height {
+			width, height, found, video = w, h, true, v
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, ErrUnknownResolution
+	}
+	return width, height, nil
+}
+
+// Get the display resolution of the track by its track header, and whether the track is a video track.
+func trackResolution(trak []byte) (width, height int64, video bool, err error) {
+	err = walkBoxes(trak, func(typ string, body []byte) error {
+		switch typ {
+		case "tkhd":
+			width, height = trackHeaderResolution(body)
+		case "mdia":
+			return walkBoxes(body, func(typ string, body []byte) error {
+				// The handler type follows the version, flags and pre-defined fields.
+				if typ == "hdlr" && len(body) >= 12 {
+					video = string(body[8:12]) == "vide"
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return width, height, video, err
+}
+
+// Get the width and height of the track in 16.16 fixed-point numbers from the track header.
+// The width and height are swapped if the transformation matrix rotates the track by 90 or 270 degrees.
+func trackHeaderResolution(tkhd []byte) (width, height int64) {
+	// The matrix and the resolution are placed after the 32-bit times and duration in version 0,
+	// or the 64-bit ones in version 1.
+	matrix, resolution := 40, 76
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		matrix, resolution = 52, 88
+	}
+	if len(tkhd) < resolution+8 {
+		return 0, 0
+	}
+	width = int64(binary.BigEndian.Uint32(tkhd[resolution:]) >> 16)
+	height = int64(binary.BigEndian.Uint32(tkhd[resolution+4:]) >> 16)
+	// The matrix is {a, b, u, c, d, v, x, y, w}, b is non-zero if the track is rotated by 90 or 270 degrees.
+	if binary.BigEndian.Uint32(tkhd[matrix+4:]) != 0 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// Call the function with the type and the body of every box in the given bytes.
+func walkBoxes(b []byte, fn func(typ string, body []byte) error) error {
+	for len(b) >= 8 {
+		size, headerSize := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return ErrInvalidBox
+			}
+			size, headerSize = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return ErrInvalidBox
+		}
+		if err := fn(string(b[4:8]), b[headerSize:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// Read the range of the given length from the source.
+func readRange(src httprange.Source, start, length int64) ([]byte, error) {
+	body, err := src.ReadRange(start, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	b := make([]byte, length)
+	if _, err = io.ReadFull(body, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/molpadia/molpastream/internal/httprange"
+)
+
+// Build the box of the given type with the bodies of its children.
+func box(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+// Build the track header of the given version, rotated by 90 degrees if rotated is true.
+func tkhd(version byte, width, height uint32, rotated bool) []byte {
+	matrix, resolution := 40, 76
+	if version == 1 {
+		matrix, resolution = 52, 88
+	}
+	b := make([]byte, resolution+8)
+	b[0] = version
+	if rotated {
+		binary.BigEndian.PutUint32(b[matrix+4:], 0x00010000)
+	}
+	binary.BigEndian.PutUint32(b[resolution:], width<<16)
+	binary.BigEndian.PutUint32(b[resolution+4:], height<<16)
+	return box("tkhd", b)
+}
+
+// Build the track of the given handler type.
+func trak(header []byte, handler string) []byte {
+	return box("trak", header, box("mdia", box("hdlr", make([]byte, 8), []byte(handler))))
+}
+
+func TestResolution(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"))
+	mdat := box("mdat", make([]byte, 1024))
+	tests := []struct {
+		file           []byte
+		expectedWidth  int64
+		expectedHeight int64
+		expectedErr    error
+	}{
+		{bytes.Join([][]byte{ftyp, mdat, box("moov", trak(tkhd(0, 1920, 1080, false), "vide"))}, nil), 1920, 1080, nil},
+		{bytes.Join([][]byte{ftyp, box("moov", trak(tkhd(1, 3840, 2160, false), "vide")), mdat}, nil), 3840, 2160, nil},
+		// The display resolution of rotated tracks recorded by phones is in portrait orientation.
+		{bytes.Join([][]byte{ftyp, box("moov", trak(tkhd(0, 1280, 720, true), "vide"))}, nil), 720, 1280, nil},
+		// Tracks other than video tracks, e.g. the cover art, are ignored.
+		{bytes.Join([][]byte{ftyp, box("moov", trak(tkhd(0, 3000, 3000, false), "pict"), trak(tkhd(0, 640, 360, false), "vide"), trak(tkhd(0, 0, 0, false), "soun"))}, nil), 640, 360, nil},
+		{bytes.Join([][]byte{ftyp, box("moov", trak(tkhd(0, 0, 0, false), "soun"))}, nil), 0, 0, ErrUnknownResolution},
+		{bytes.Join([][]byte{ftyp, mdat}, nil), 0, 0, ErrUnknownResolution},
+		{append(ftyp, 0, 0, 1, 0, 'm', 'o', 'o', 'v'), 0, 0, ErrInvalidBox},
+	}
+	for i, tt := range tests {
+		width, height, err := Resolution(httprange.ReaderAtSource(bytes.NewReader(tt.file)), int64(len(tt.file)))
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("case %d: expected error (%v), got error (%v)", i, tt.expectedErr, err)
+		}
+		if width != tt.expectedWidth || height != tt.expectedHeight {
+			t.Errorf("case %d: expected resolution %dx%d, got %dx%d", i, tt.expectedWidth, tt.expectedHeight, width, height)
+		}
+	}
+}
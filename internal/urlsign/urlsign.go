@@ -0,0 +1,107 @@
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query parameters of signed URLs.
+const (
+	ExpiresParam   = "expires"
+	IPParam        = "ip"
+	UserParam      = "user"
+	ScopeParam     = "scope"
+	SignatureParam = "signature"
+)
+
+var (
+	ErrMissingSignature = errors.New("URL is not signed")
+	ErrInvalidSignature = errors.New("invalid signature of URL")
+	ErrExpired          = errors.New("signed URL has expired")
+	ErrIPMismatch       = errors.New("signed URL is bound to another client IP")
+	ErrUserMismatch     = errors.New("signed URL is bound to another user")
+)
+
+// The access granted by a signed URL, an empty IP or user grants the access to any client.
+type Grant struct {
+	Expires time.Time // The time when the URL expires.
+	IP      string    // The IP address of the client the URL is bound to.
+	User    string    // The ID of the user the URL is bound to.
+	// The path prefix the URL grants access to, e.g. the segments named by templates.
+	// Only the signed path is accessible if the scope is empty.
+	Scope string
+}
+
+// The signer signs URL paths with HMAC-SHA256 of the secret key.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key}
+}
+
+// Sign the URL path with the grant, return the query parameters of the signed URL.
+func (s *Signer) Sign(path string, g Grant) url.Values {
+	q := url.Values{}
+	q.Set(ExpiresParam, strconv.FormatInt(g.Expires.Unix(), 10))
+	if g.IP != "" {
+		q.Set(IPParam, g.IP)
+	}
+	if g.User != "" {
+		q.Set(UserParam, g.User)
+	}
+	if g.Scope != "" {
+		q.Set(ScopeParam, g.Scope)
+		path = g.Scope
+	}
+	q.Set(SignatureParam, s.signature(path, q))
+	return q
+}
+
+// Verify the signature of the URL path and its query parameters for the client of the given IP and user at the given time.
+// The grant of the URL is returned if the URL is valid.
+func (s *Signer) Verify(path string, q url.Values, now time.Time, ip, user string) (*Grant, error) {
+	signature := q.Get(SignatureParam)
+	if signature == "" {
+		return nil, ErrMissingSignature
+	}
+	expires, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	scope := q.Get(ScopeParam)
+	if scope != "" {
+		if !strings.HasPrefix(path, scope) {
+			return nil, ErrInvalidSignature
+		}
+		path = scope
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(path, q))) {
+		return nil, ErrInvalidSignature
+	}
+	g := &Grant{Expires: time.Unix(expires, 0), IP: q.Get(IPParam), User: q.Get(UserParam), Scope: scope}
+	if now.After(g.Expires) {
+		return nil, ErrExpired
+	}
+	if g.IP != "" && g.IP != ip {
+		return nil, ErrIPMismatch
+	}
+	if g.User != "" && g.User != user {
+		return nil, ErrUserMismatch
+	}
+	return g, nil
+}
+
+// Get the signature of the URL path or scope and the grant in the query parameters, encoded in base64 URL encoding.
+func (s *Signer) signature(path string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strings.Join([]string{path, q.Get(ExpiresParam), q.Get(IPParam), q.Get(UserParam), q.Get(ScopeParam)}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,51 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	now := time.Unix(1700000000, 0)
+	const path = "/molpastream/v1/videos/1/media"
+	tests := []struct {
+		query       url.Values
+		path        string
+		ip, user    string
+		expectedErr error
+	}{
+		{s.Sign(path, Grant{Expires: now.Add(time.Hour)}), path, "", "", nil},
+		{s.Sign(path, Grant{Expires: now.Add(time.Hour), IP: "10.0.0.1", User: "foo"}), path, "10.0.0.1", "foo", nil},
+		{url.Values{}, path, "", "", ErrMissingSignature},
+		{s.Sign(path, Grant{Expires: now.Add(-time.Second)}), path, "", "", ErrExpired},
+		{s.Sign(path, Grant{Expires: now.Add(time.Hour)}), "/molpastream/v1/videos/2/media", "", "", ErrInvalidSignature},
+		{NewSigner([]byte("foo")).Sign(path, Grant{Expires: now.Add(time.Hour)}), path, "", "", ErrInvalidSignature},
+		{s.Sign(path, Grant{Expires: now.Add(time.Hour), IP: "10.0.0.1"}), path, "10.0.0.2", "", ErrIPMismatch},
+		{s.Sign(path, Grant{Expires: now.Add(time.Hour), User: "foo"}), path, "", "bar", ErrUserMismatch},
+		{s.Sign("", Grant{Expires: now.Add(time.Hour), Scope: "/molpastream/v1/videos/1/"}), path, "", "", nil},
+		{s.Sign("", Grant{Expires: now.Add(time.Hour), Scope: "/molpastream/v1/videos/2/"}), path, "", "", ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		g, err := s.Verify(tt.path, tt.query, now, tt.ip, tt.user)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("expected error (%v) of %s?%s, got error (%v)", tt.expectedErr, tt.path, tt.query.Encode(), err)
+		}
+		if err == nil && (g.IP != tt.ip || g.User != tt.user || !g.Expires.Equal(now.Add(time.Hour))) {
+			t.Errorf("expected grant of %s?%s, got %+v", tt.path, tt.query.Encode(), g)
+		}
+	}
+	// The grant of the URL cannot be tampered with.
+	q := s.Sign(path, Grant{Expires: now.Add(time.Hour)})
+	q.Set(ExpiresParam, "1800000000")
+	if _, err := s.Verify(path, q, now, "", ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected error (%v) of tampered expiry, got error (%v)", ErrInvalidSignature, err)
+	}
+	q = s.Sign(path, Grant{Expires: now.Add(time.Hour)})
+	q.Set(ScopeParam, "/")
+	if _, err := s.Verify(path, q, now, "", ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected error (%v) of widened scope, got error (%v)", ErrInvalidSignature, err)
+	}
+}